@@ -0,0 +1,37 @@
+#!/bin/bash
+# This script executes the function named by its first argument, as defined in
+# configuration files located in the /etc/powerd/board directory. It runs the
+# confs the same way the power daemon does, so it can be used to test a board
+# config by hand. The remaining arguments are passed to the function.
+
+BOARD_DIR=/etc/powerd/board
+FUNC=$1
+shift
+
+main() {
+  # The conf process writes a marker to fd 3, redirected to this file, when
+  # the function is not defined, so any exit code of the function is reported
+  local marker=$(mktemp)
+  trap 'rm -f "${marker}"' EXIT
+  # Iterate over all .conf files in the BOARD_DIR directory in lexical order
+  for conf in $(ls ${BOARD_DIR}/*.conf 2>/dev/null | sort); do
+    # Check if the configuration file is readable
+    if [ -r $conf ]; then
+      : > "${marker}"
+      # Source the configuration file in its own bash process, so that the
+      # confs cannot affect each other
+      bash -c 'source "$1" 3>&- || exit $?
+        if ! declare -F "$2" >/dev/null; then echo undefined >&3; exit 0; fi
+        exec 3>&-
+        func="$2"; shift 2; "${func}" "$@"' bash $conf $FUNC "$@" 3>"${marker}"
+      ret=$?
+      # Report the result unless the function is not defined in the conf
+      if [ ! -s "${marker}" ]; then
+        echo "${FUNC} in $(basename $conf): exit code ${ret}"
+      fi
+    fi
+  done
+}
+
+# Call the main function
+main "$@"
\ No newline at end of file
@@ -5,6 +5,7 @@ import (
     "fmt"
     "io/ioutil"
     "log"
+    "math"
     "os"
     "os/exec"
     "strconv"
@@ -18,13 +19,18 @@ import (
 const (
     sigScreenBrightnessChanged   = "ScreenBrightnessChanged"
     sigKeyBoardBrightnessChanged = "KeyboardBrightnessChanged"
+    sigSuspendDone               = "SuspendDone"
     methdSetScreenBrightness     = "SetScreenBrightness"
+    methdGetScreenBrightness     = "GetScreenBrightnessPercent"
+    methdGetKeyboardBrightness   = "GetKeyboardBrightnessPercent"
     pathConfig                   = "/mnt/stateful_partition/unencrypted/hwconfig"
     fileBrightness               = "ScreenBrightness"
     fileKeyboardBrightness       = "KeyBoardBrightness"
     defaultBrightness            = 60.0
     minBrightness                = 10.0
     backlightTool                = "/usr/bin/backlight_tool"
+    // Brightness differences below this percentage are not treated as drift.
+    brightnessTolerance          = 0.5
 )
 
 // ScreenBrightnessManager manages screen and keyboard brightness settings.
@@ -35,6 +41,22 @@ type ScreenBrightnessManager struct {
     need_store_screen   bool
     keyboard_brightness float64
     need_store_keyboard bool
+    // Set while the daemon itself is restoring a level after resume, so the
+    // resulting brightness change signal is not stored as a user change.
+    restoring_screen    bool
+    restoring_keyboard  bool
+    // Set once a user level was stored or recorded; until then the levels
+    // powerd picks after resume are left alone.
+    has_screen          bool
+    has_keyboard        bool
+    // Ceilings of the screen brightness by owner, e.g. battery saver, and the
+    // keyboard backlight switch, with the levels to return to when they are
+    // lifted. A user change lifts them, except the pinned caps.
+    screen_caps         map[string]float64
+    screen_pinned       map[string]bool
+    uncapped_screen     float64
+    keyboard_off        bool
+    keyboard_before_off float64
 }
 
 // getHWConfig reads hardware configuration values from the specified file.
@@ -68,18 +90,20 @@ func saveHWConfig(name string, value string) error {
 // NewScreenBrightnessManager initializes a new ScreenBrightnessManager instance.
 func NewScreenBrightnessManager(ctx context.Context, conn *dbus.Conn) (bm *ScreenBrightnessManager) {
     bm = &ScreenBrightnessManager{ctx, dbusutil.GetPMObject(conn),
-        defaultBrightness, false, 0, false}
+        defaultBrightness, false, 0, false, false, false, false, false, make(map[string]float64), make(map[string]bool), 0, false, 0}
     if value, err := getHWConfig(fileBrightness); err == nil {
         log.Printf("read hardware config; screen brightness:%s", value)
         bm.screen_brightness, _ = strconv.ParseFloat(value, 64)
+        bm.has_screen = true
     } else {
-        log.Printf("read error:", err)
+        log.Printf("read error: %v", err)
     }
     if value, err := getHWConfig(fileKeyboardBrightness); err == nil {
         log.Printf("read hardware config; keyboard brightness:%s", value)
         bm.keyboard_brightness, _ = strconv.ParseFloat(value, 64)
+        bm.has_keyboard = true
     } else {
-        log.Printf("read error:", err)
+        log.Printf("read error: %v", err)
     }
     return
 }
@@ -90,13 +114,35 @@ func (bm *ScreenBrightnessManager) HandleSetScreenBrightness(signal *dbus.Signal
     brightChg := &pmpb.BacklightBrightnessChange{}
     if err := dbusutil.DecodeSignal(signal, brightChg); err != nil {
         return err
-	}
+    }
+    if bm.restoring_screen {
+        bm.restoring_screen = false
+        if math.Abs(brightChg.GetPercent()-bm.screenLevel()) < brightnessTolerance {
+            log.Printf("Ignore screen brightness change caused by restore")
+            return nil
+        }
+    }
     if brightChg.GetCause() == pmpb.BacklightBrightnessChange_USER_REQUEST {
+        for owner, percent := range bm.screen_caps {
+            if !bm.screen_pinned[owner] {
+                log.Printf("User changed screen brightness, lift the cap %v of %s", percent, owner)
+                delete(bm.screen_caps, owner)
+            }
+        }
         if brightChg.GetPercent() > minBrightness && bm.screen_brightness != brightChg.GetPercent() {
             bm.screen_brightness = brightChg.GetPercent()
             bm.need_store_screen = true
+            bm.has_screen = true
         }
         log.Printf("User set screen brightness to %v", bm.screen_brightness)
+        if ceiling := bm.screenCap(); ceiling > 0 {
+            // The pinned caps stay; the user's level applies once they are lifted.
+            bm.uncapped_screen = brightChg.GetPercent()
+            if brightChg.GetPercent() > ceiling {
+                log.Printf("Keep screen brightness capped to %v", ceiling)
+                return bm.setScreenLevel(ceiling)
+            }
+        }
     }
     return nil
 }
@@ -108,51 +154,242 @@ func (bm *ScreenBrightnessManager) HandleSetKeyboardBrightness(signal *dbus.Sign
     if err := dbusutil.DecodeSignal(signal, brightChg); err != nil {
         return err
     }
+    if bm.restoring_keyboard {
+        bm.restoring_keyboard = false
+        if math.Abs(brightChg.GetPercent()-bm.keyboardLevel()) < brightnessTolerance {
+            log.Printf("Ignore keyboard brightness change caused by restore")
+            return nil
+        }
+    }
     if brightChg.GetCause() == pmpb.BacklightBrightnessChange_USER_REQUEST {
+        if bm.keyboard_off {
+            log.Println("User changed keyboard brightness, keep the backlight on")
+            bm.keyboard_off = false
+        }
         if bm.keyboard_brightness != brightChg.GetPercent() {
             bm.keyboard_brightness = brightChg.GetPercent()
             bm.need_store_keyboard = true
+            bm.has_keyboard = true
         }
         log.Printf("User set keyboard brightness to %v", bm.keyboard_brightness)
     }
     return nil
 }
 
-// SetScreenBrightness applies the current screen brightness setting.
-func (bm *ScreenBrightnessManager) SetScreenBrightness() error {
-    log.Printf("Set screen brightness to: %v", bm.screen_brightness)
+// screenCap returns the lowest screen brightness cap, or 0 if there is none.
+func (bm *ScreenBrightnessManager) screenCap() float64 {
+    ceiling := 0.0
+    for _, percent := range bm.screen_caps {
+        if ceiling == 0 || percent < ceiling {
+            ceiling = percent
+        }
+    }
+    return ceiling
+}
+
+// screenLevel returns the screen brightness setting limited by the caps.
+func (bm *ScreenBrightnessManager) screenLevel() float64 {
+    if ceiling := bm.screenCap(); ceiling > 0 && bm.screen_brightness > ceiling {
+        return ceiling
+    }
+    return bm.screen_brightness
+}
+
+// keyboardLevel returns the keyboard brightness setting, 0 while switched off.
+func (bm *ScreenBrightnessManager) keyboardLevel() float64 {
+    if bm.keyboard_off {
+        return 0
+    }
+    return bm.keyboard_brightness
+}
+
+// setScreenLevel asks the Power Manager to set the screen brightness.
+func (bm *ScreenBrightnessManager) setScreenLevel(percent float64) error {
+    log.Printf("Set screen brightness to: %v", percent)
     trans := pmpb.SetBacklightBrightnessRequest_INSTANT
     cause := pmpb.SetBacklightBrightnessRequest_MODEL
     req := &pmpb.SetBacklightBrightnessRequest{
-        Percent:    &bm.screen_brightness,
+        Percent:    &percent,
         Transition: &trans,
         Cause:      &cause,
     }
     return dbusutil.CallProtoMethod(bm.ctx, bm.obj, dbusutil.GetPMMethod(methdSetScreenBrightness), req, nil)
 }
 
-// SetKeyboardBrightness applies the current keyboard brightness setting.
-func (bm *ScreenBrightnessManager) SetKeyboardBrightness() error {
+// setKeyboardLevel sets the keyboard brightness with the backlight tool.
+func (bm *ScreenBrightnessManager) setKeyboardLevel(percent float64) error {
     ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
     defer cancel()
-    log.Printf("Set keyboard backlight to: %v", bm.keyboard_brightness)
-    brightnessArg := fmt.Sprintf("--set_brightness_percent=%.1f", bm.keyboard_brightness)
+    log.Printf("Set keyboard backlight to: %v", percent)
+    brightnessArg := fmt.Sprintf("--set_brightness_percent=%.1f", percent)
     return exec.CommandContext(ctx, backlightTool, "--keyboard", brightnessArg).Run()
 }
 
+// SetScreenBrightness applies the current screen brightness setting.
+func (bm *ScreenBrightnessManager) SetScreenBrightness() error {
+    return bm.setScreenLevel(bm.screenLevel())
+}
+
+// SetKeyboardBrightness applies the current keyboard brightness setting.
+func (bm *ScreenBrightnessManager) SetKeyboardBrightness() error {
+    return bm.setKeyboardLevel(bm.keyboardLevel())
+}
+
+// CapScreenBrightness limits the screen brightness to percent on behalf of
+// owner until UncapScreenBrightness is called for owner or the user changes the
+// brightness. The lowest cap of all owners applies.
+func (bm *ScreenBrightnessManager) CapScreenBrightness(owner string, percent float64) error {
+    return bm.capScreen(owner, percent, false)
+}
+
+// PinScreenBrightness limits the screen brightness to percent on behalf of
+// owner like CapScreenBrightness, but the cap also holds against user changes,
+// e.g. while the system is hot.
+func (bm *ScreenBrightnessManager) PinScreenBrightness(owner string, percent float64) error {
+    return bm.capScreen(owner, percent, true)
+}
+
+// capScreen adds the screen brightness cap of owner.
+func (bm *ScreenBrightnessManager) capScreen(owner string, percent float64, pinned bool) error {
+    current, err := bm.GetScreenBrightness()
+    if err != nil {
+        return err
+    }
+    log.Printf("Cap screen brightness to %v for %s, pinned: %v", percent, owner, pinned)
+    if len(bm.screen_caps) == 0 {
+        bm.uncapped_screen = current
+    }
+    bm.screen_caps[owner] = percent
+    bm.screen_pinned[owner] = pinned
+    if ceiling := bm.screenCap(); current > ceiling {
+        return bm.setScreenLevel(ceiling)
+    }
+    return nil
+}
+
+// UncapScreenBrightness lifts the screen brightness cap of owner. Once no ceiling
+// is left, the brightness returns to the level from before the first one,
+// unless the user changed the brightness meanwhile.
+func (bm *ScreenBrightnessManager) UncapScreenBrightness(owner string) error {
+    if _, ok := bm.screen_caps[owner]; !ok {
+        return nil
+    }
+    log.Printf("Lift screen brightness cap of %v for %s", bm.screen_caps[owner], owner)
+    delete(bm.screen_caps, owner)
+    delete(bm.screen_pinned, owner)
+    if ceiling := bm.screenCap(); ceiling > 0 {
+        return bm.setScreenLevel(math.Min(bm.uncapped_screen, ceiling))
+    }
+    return bm.setScreenLevel(bm.uncapped_screen)
+}
+
+// UncappedScreenBrightness returns the screen brightness restored once the
+// last cap is lifted.
+func (bm *ScreenBrightnessManager) UncappedScreenBrightness() float64 {
+    return bm.uncapped_screen
+}
+
+// SetUncappedScreenBrightness replaces the screen brightness restored once the
+// last cap is lifted, e.g. with one persisted across a daemon restart.
+func (bm *ScreenBrightnessManager) SetUncappedScreenBrightness(percent float64) {
+    bm.uncapped_screen = percent
+}
+
+// KeyboardBrightnessBeforeOff returns the keyboard brightness restored by
+// KeyboardBacklightOn.
+func (bm *ScreenBrightnessManager) KeyboardBrightnessBeforeOff() float64 {
+    return bm.keyboard_before_off
+}
+
+// SetKeyboardBrightnessBeforeOff replaces the keyboard brightness restored by
+// KeyboardBacklightOn, e.g. with one persisted across a daemon restart.
+func (bm *ScreenBrightnessManager) SetKeyboardBrightnessBeforeOff(percent float64) {
+    bm.keyboard_before_off = percent
+}
+
+// KeyboardBacklightOff switches the keyboard backlight off until
+// KeyboardBacklightOn is called or the user changes the keyboard brightness.
+func (bm *ScreenBrightnessManager) KeyboardBacklightOff() error {
+    current, err := bm.GetKeyboardBrightness()
+    if err != nil {
+        return err
+    }
+    bm.keyboard_off, bm.keyboard_before_off = true, current
+    if current == 0 {
+        return nil
+    }
+    return bm.setKeyboardLevel(0)
+}
+
+// KeyboardBacklightOn returns the keyboard backlight to the level from before
+// KeyboardBacklightOff, unless the user changed the brightness meanwhile.
+func (bm *ScreenBrightnessManager) KeyboardBacklightOn() error {
+    if !bm.keyboard_off {
+        return nil
+    }
+    bm.keyboard_off = false
+    return bm.setKeyboardLevel(bm.keyboard_before_off)
+}
+
+// GetScreenBrightness queries the Power Manager for the current screen brightness.
+func (bm *ScreenBrightnessManager) GetScreenBrightness() (percent float64, err error) {
+    err = dbusutil.CallMethod(bm.ctx, bm.obj, dbusutil.GetPMMethod(methdGetScreenBrightness), &percent)
+    return
+}
+
+// GetKeyboardBrightness queries the Power Manager for the current keyboard brightness.
+func (bm *ScreenBrightnessManager) GetKeyboardBrightness() (percent float64, err error) {
+    err = dbusutil.CallMethod(bm.ctx, bm.obj, dbusutil.GetPMMethod(methdGetKeyboardBrightness), &percent)
+    return
+}
+
+// HandleResume restores the user's brightness levels if the firmware brought
+// the backlights back from suspend at a different level. A backlight without
+// a recorded user level is left alone, unless it is switched off.
+func (bm *ScreenBrightnessManager) HandleResume(signal *dbus.Signal) error {
+    log.Println("Get Suspend Done signal, check brightness")
+    if !bm.has_screen {
+        log.Println("No user screen brightness recorded, skip restore")
+    } else if percent, err := bm.GetScreenBrightness(); err != nil {
+        log.Printf("Get screen brightness error: %v", err)
+    } else if math.Abs(percent-bm.screenLevel()) >= brightnessTolerance {
+        log.Printf("Screen brightness drifted to %v after resume", percent)
+        bm.restoring_screen = true
+        if err := bm.SetScreenBrightness(); err != nil {
+            bm.restoring_screen = false
+            log.Printf("Restore screen brightness error: %v", err)
+        }
+    }
+    if !bm.has_keyboard && !bm.keyboard_off {
+        log.Println("No user keyboard brightness recorded, skip restore")
+    } else if percent, err := bm.GetKeyboardBrightness(); err != nil {
+        log.Printf("Get keyboard brightness error: %v", err)
+    } else if math.Abs(percent-bm.keyboardLevel()) >= brightnessTolerance {
+        log.Printf("Keyboard brightness drifted to %v after resume", percent)
+        bm.restoring_keyboard = true
+        if err := bm.SetKeyboardBrightness(); err != nil {
+            bm.restoring_keyboard = false
+            log.Printf("Restore keyboard brightness error: %v", err)
+        }
+    }
+    return nil
+}
+
 // Register registers the brightness manager with the signal server.
 func (bm *ScreenBrightnessManager) Register(sigServer *dbusutil.SignalServer) error {
     if err := bm.SetScreenBrightness(); err != nil {
-        log.Printf("Set screen brightness error:%w", err)
+        log.Printf("Set screen brightness error:%v", err)
     }
     if err := bm.SetKeyboardBrightness(); err != nil {
-        log.Printf("Set keyboard brightness error:%w", err)
+        log.Printf("Set keyboard brightness error:%v", err)
     }
-    var sbl_handler, kbl_handler dbusutil.SignalHandler
+    var sbl_handler, kbl_handler, resume_handler dbusutil.SignalHandler
     sbl_handler = func(sig *dbus.Signal) error { return bm.HandleSetScreenBrightness(sig) }
     kbl_handler = func(sig *dbus.Signal) error { return bm.HandleSetKeyboardBrightness(sig) }
+    resume_handler = func(sig *dbus.Signal) error { return bm.HandleResume(sig) }
     sigServer.RegisterSignalHandler(sigScreenBrightnessChanged, sbl_handler)
     sigServer.RegisterSignalHandler(sigKeyBoardBrightnessChanged, kbl_handler)
+    sigServer.RegisterSignalHandler(sigSuspendDone, resume_handler)
     log.Println("Register brightness manager")
     return nil
 }
@@ -161,12 +398,12 @@ func (bm *ScreenBrightnessManager) Register(sigServer *dbusutil.SignalServer) er
 func (bm *ScreenBrightnessManager) UnRegister(sigServer *dbusutil.SignalServer) error {
     if bm.need_store_screen {
         if err := saveHWConfig(fileBrightness, strconv.FormatFloat(bm.screen_brightness, 'f', 1, 64)); err != nil {
-            log.Printf("Get error when save %s, error: %w", fileBrightness, err)
+            log.Printf("Get error when save %s, error: %v", fileBrightness, err)
         }
     }
     if bm.need_store_keyboard {
         if err := saveHWConfig(fileKeyboardBrightness, strconv.FormatFloat(bm.keyboard_brightness, 'f', 1, 64)); err != nil {
-            log.Printf("Get error when save %s, error: %w", fileKeyboardBrightness, err)
+            log.Printf("Get error when save %s, error: %v", fileKeyboardBrightness, err)
         }
     }
     log.Println("Unregister brightness manager")
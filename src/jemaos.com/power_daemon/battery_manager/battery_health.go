@@ -0,0 +1,276 @@
+package battery_manager
+
+import (
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/config"
+)
+
+const (
+    // D-Bus method name for querying the battery health.
+    methdGetBatteryHealth = "GetBatteryHealth"
+
+    // File keeping the battery health samples, relative to the state directory.
+    fileBatteryHealth = "battery_health.json"
+
+    // Interval between the checks for a daily sample.
+    healthCheckInterval = time.Hour
+
+    // Minimum interval between the samples taken after resume. A resume sample
+    // replaces the earlier sample of the same day.
+    healthResumeInterval = time.Hour
+
+    // Period over which the wear trend is computed, and the unit it is reported in.
+    healthTrendPeriod = 180 * 24 * time.Hour
+    healthTrendUnit   = 30 * 24 * time.Hour
+
+    // Number of samples kept in the health file, one per day over the trend
+    // period.
+    healthSize = int(healthTrendPeriod / (24 * time.Hour))
+
+    // Scope of the power supplies of peripherals, such as HID devices.
+    scopeDevice = "Device"
+)
+
+// HealthSample is a single battery health reading. Charges are in µAh, or in
+// µWh for batteries that only report energy.
+type HealthSample struct {
+    Time       int64 `json:"t"`
+    Full       int64 `json:"full"`
+    FullDesign int64 `json:"design"`
+    CycleCount int64 `json:"cycles"`
+    VoltageUv  int64 `json:"uv"`
+    // Temp is the battery temperature in tenths of a degree Celsius.
+    Temp       int64 `json:"temp"`
+}
+
+// Wear returns the lost capacity in percent of the design capacity.
+func (sample *HealthSample) Wear() float64 {
+    if sample.FullDesign <= 0 {
+        return 0
+    }
+    return 100 * (1 - float64(sample.Full)/float64(sample.FullDesign))
+}
+
+// BatteryHealth is the reply of the GetBatteryHealth D-Bus method.
+type BatteryHealth struct {
+    Battery     string         `json:"battery"`
+    WearPercent float64        `json:"wear_percent"`
+    // WearTrend is the wear change in percent per 30 days.
+    WearTrend   float64        `json:"wear_trend_percent_per_month"`
+    CycleCount  int64          `json:"cycle_count"`
+    Samples     []HealthSample `json:"samples"`
+}
+
+// batteryHealth keeps a time series of battery health samples, persisted to
+// disk after every sample. It is read from godbus goroutines, so all state is
+// guarded by mutex.
+type batteryHealth struct {
+    mutex   sync.Mutex
+    root    string
+    path    string
+    battery string
+    samples []HealthSample
+}
+
+// newBatteryHealth loads the battery health samples from the state directory.
+func newBatteryHealth(root string) *batteryHealth {
+    health := &batteryHealth{root: root, path: filepath.Join(config.PathStateDir, fileBatteryHealth)}
+    buf, err := ioutil.ReadFile(health.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("Read battery health error: %v", err)
+        }
+        return health
+    }
+    if err := json.Unmarshal(buf, &health.samples); err != nil {
+        log.Printf("Parse battery health error: %v", err)
+    }
+    return health
+}
+
+// readAttr reads an integer sysfs attribute of the battery.
+func (health *batteryHealth) readAttr(attr string) (int64, error) {
+    buf, err := ioutil.ReadFile(filepath.Join(health.root, health.battery, attr))
+    if err != nil {
+        return 0, err
+    }
+    return strconv.ParseInt(strings.TrimSpace(string(buf)), 10, 64)
+}
+
+// readSupplyAttr reads a string sysfs attribute of a power supply.
+func (health *batteryHealth) readSupplyAttr(supply, attr string) string {
+    buf, _ := ioutil.ReadFile(filepath.Join(health.root, supply, attr))
+    return strings.TrimSpace(string(buf))
+}
+
+// findBattery returns the first system battery in the power_supply tree.
+// Batteries of peripherals, such as hid-* and hidpp_battery_*, are skipped.
+func (health *batteryHealth) findBattery() (string, error) {
+    entries, err := ioutil.ReadDir(health.root)
+    if err != nil {
+        return "", err
+    }
+    for _, entry := range entries {
+        if health.readSupplyAttr(entry.Name(), "type") == "Battery" &&
+            health.readSupplyAttr(entry.Name(), "scope") != scopeDevice {
+            return entry.Name(), nil
+        }
+    }
+    return "", errors.New("no battery found")
+}
+
+// read reads a health sample from sysfs. Called with mutex held.
+func (health *batteryHealth) read() (*HealthSample, error) {
+    if health.battery == "" {
+        battery, err := health.findBattery()
+        if err != nil {
+            return nil, err
+        }
+        health.battery = battery
+    }
+    sample := &HealthSample{Time: time.Now().Unix()}
+    var err error
+    if sample.Full, err = health.readAttr("charge_full"); err == nil {
+        sample.FullDesign, err = health.readAttr("charge_full_design")
+    } else if sample.Full, err = health.readAttr("energy_full"); err == nil {
+        sample.FullDesign, err = health.readAttr("energy_full_design")
+    }
+    if err != nil {
+        return nil, err
+    }
+    // Not every driver reports these.
+    sample.CycleCount, _ = health.readAttr("cycle_count")
+    sample.VoltageUv, _ = health.readAttr("voltage_now")
+    sample.Temp, _ = health.readAttr("temp")
+    return sample, nil
+}
+
+// sample records a health sample and saves the time series to disk.
+func (health *batteryHealth) sample() error {
+    health.mutex.Lock()
+    defer health.mutex.Unlock()
+    sample, err := health.read()
+    if err != nil {
+        return err
+    }
+    log.Printf("Battery %s wear %.1f%%, %d cycles", health.battery, sample.Wear(), sample.CycleCount)
+    // The time series keeps one sample per day, the latest one.
+    if n := len(health.samples); n > 0 && sameDay(health.samples[n-1].Time, sample.Time) {
+        health.samples[n-1] = *sample
+    } else {
+        health.samples = append(health.samples, *sample)
+    }
+    if len(health.samples) > healthSize {
+        health.samples = health.samples[len(health.samples)-healthSize:]
+    }
+    return health.save()
+}
+
+// sameDay reports whether two Unix times fall on the same local day.
+func sameDay(a, b int64) bool {
+    ya, ma, da := time.Unix(a, 0).Date()
+    yb, mb, db := time.Unix(b, 0).Date()
+    return ya == yb && ma == mb && da == db
+}
+
+// last returns the Unix time of the last sample, or 0 if there is none.
+func (health *batteryHealth) last() int64 {
+    health.mutex.Lock()
+    defer health.mutex.Unlock()
+    if len(health.samples) == 0 {
+        return 0
+    }
+    return health.samples[len(health.samples)-1].Time
+}
+
+// sampleDaily records a health sample if there is none of today yet.
+func (health *batteryHealth) sampleDaily() error {
+    if sameDay(health.last(), time.Now().Unix()) {
+        return nil
+    }
+    return health.sample()
+}
+
+// sampleResume records a health sample after resume, at most once per
+// healthResumeInterval.
+func (health *batteryHealth) sampleResume() error {
+    if time.Since(time.Unix(health.last(), 0)) < healthResumeInterval {
+        return nil
+    }
+    return health.sample()
+}
+
+// save writes the time series to disk. Called with mutex held.
+func (health *batteryHealth) save() error {
+    buf, err := json.Marshal(health.samples)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(health.path), 0755); err != nil {
+        return err
+    }
+    tmp := health.path + ".tmp"
+    if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, health.path)
+}
+
+// trend returns the least-squares slope of the wear over the trend period, in
+// percent per trend unit. Called with mutex held.
+func (health *batteryHealth) trend() float64 {
+    if len(health.samples) < 2 {
+        return 0
+    }
+    last := health.samples[len(health.samples)-1].Time
+    since := last - int64(healthTrendPeriod/time.Second)
+    var n, sumX, sumY, sumXX, sumXY float64
+    for _, sample := range health.samples {
+        if sample.Time < since || sample.FullDesign <= 0 {
+            continue
+        }
+        x := float64(sample.Time-last) / float64(healthTrendUnit/time.Second)
+        y := sample.Wear()
+        n, sumX, sumY, sumXX, sumXY = n+1, sumX+x, sumY+y, sumXX+x*x, sumXY+x*y
+    }
+    denom := n*sumXX - sumX*sumX
+    if n < 2 || denom == 0 {
+        return 0
+    }
+    return (n*sumXY - sumX*sumY) / denom
+}
+
+// get implements the GetBatteryHealth D-Bus method. It returns the current wear,
+// its trend and the most recent count samples, oldest first, as JSON; 0
+// returns all of them.
+func (health *batteryHealth) get(count uint32) (string, *dbus.Error) {
+    health.mutex.Lock()
+    defer health.mutex.Unlock()
+    reply := BatteryHealth{Battery: health.battery, WearTrend: health.trend(), Samples: health.samples}
+    if len(health.samples) > 0 {
+        last := health.samples[len(health.samples)-1]
+        reply.WearPercent, reply.CycleCount = last.Wear(), last.CycleCount
+    }
+    if count > 0 && int(count) < len(reply.Samples) {
+        reply.Samples = reply.Samples[len(reply.Samples)-int(count):]
+    }
+    if reply.Samples == nil {
+        reply.Samples = []HealthSample{}
+    }
+    buf, err := json.Marshal(&reply)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
\ No newline at end of file
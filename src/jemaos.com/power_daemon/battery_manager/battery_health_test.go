@@ -0,0 +1,159 @@
+package battery_manager
+
+import (
+    "os"
+    "testing"
+    "time"
+
+    "jemaos.com/power_daemon/sysfsutil/sysfstest"
+)
+
+// newTestHealth returns a batteryHealth working on a fake power_supply tree
+// with the given files, and a temporary state directory.
+func newTestHealth(t *testing.T, files map[string]string) *batteryHealth {
+    root := t.TempDir()
+    sysfstest.WriteFiles(t, root, files)
+    sysfstest.StateDir(t)
+    return newBatteryHealth(root)
+}
+
+func TestFindBattery(t *testing.T) {
+    tests := []struct {
+        name     string
+        files map[string]string
+        want  string
+    }{
+        {"system battery", map[string]string{
+            "AC/type":   "Mains",
+            "BAT0/type": "Battery",
+        }, "BAT0"},
+        {"system scope", map[string]string{
+            "BAT0/type":  "Battery",
+            "BAT0/scope": "System",
+        }, "BAT0"},
+        {"peripherals skipped", map[string]string{
+            "BAT1/type":                           "Battery",
+            "hid-00:11:22:33:44:55-battery/type":  "Battery",
+            "hid-00:11:22:33:44:55-battery/scope": "Device",
+            "hidpp_battery_0/type":                "Battery",
+            "hidpp_battery_0/scope":               "Device",
+        }, "BAT1"},
+        {"peripherals only", map[string]string{
+            "hidpp_battery_0/type":  "Battery",
+            "hidpp_battery_0/scope": "Device",
+        }, ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := newTestHealth(t, test.files).findBattery()
+            if test.want == "" {
+                if err == nil {
+                    t.Errorf("Got battery %q, want error", got)
+                }
+                return
+            }
+            if err != nil || got != test.want {
+                t.Errorf("Got battery %q, error: %v, want %q", got, err, test.want)
+            }
+        })
+    }
+}
+
+// chargeFiles are the attributes of a battery reporting charge.
+var chargeFiles = map[string]string{"BAT0/type": "Battery", "BAT0/charge_full": "4500000",
+    "BAT0/charge_full_design": "5000000", "BAT0/cycle_count": "120"}
+
+func TestSampleRate(t *testing.T) {
+    now := time.Now()
+    tests := []struct {
+        name    string
+        last    time.Duration
+        sample  func(*batteryHealth) error
+        // sampled is set if a sample is taken, which replaces the last one
+        // of the same day.
+        sampled bool
+    }{
+        {"no samples", -1, (*batteryHealth).sampleResume, true},
+        {"resume within an hour", 30 * time.Minute, (*batteryHealth).sampleResume, false},
+        {"resume after an hour", 2 * time.Hour, (*batteryHealth).sampleResume, true},
+        {"resume after a day", 25 * time.Hour, (*batteryHealth).sampleResume, true},
+        {"daily on the same day", 0, (*batteryHealth).sampleDaily, false},
+        {"daily after a day", 25 * time.Hour, (*batteryHealth).sampleDaily, true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            health := newTestHealth(t, chargeFiles)
+            var last int64
+            if test.last >= 0 {
+                last = now.Add(-test.last).Unix()
+                health.samples = []HealthSample{{Time: last}}
+            }
+            if err := test.sample(health); err != nil {
+                t.Fatalf("Got error: %v", err)
+            }
+            want := 1
+            if test.sampled && test.last >= 0 && !sameDay(last, time.Now().Unix()) {
+                want = 2
+            }
+            if len(health.samples) != want {
+                t.Fatalf("Got %d samples, want %d", len(health.samples), want)
+            }
+            sample := health.samples[len(health.samples)-1]
+            if !test.sampled {
+                if sample.Time != last {
+                    t.Errorf("Got sample %+v, want the last one kept", sample)
+                }
+                return
+            }
+            if sample.Full != 4500000 || sample.FullDesign != 5000000 || sample.CycleCount != 120 {
+                t.Errorf("Got sample %+v", sample)
+            }
+            if _, err := os.Stat(health.path); err != nil {
+                t.Errorf("Samples not saved, error: %v", err)
+            }
+        })
+    }
+}
+
+func TestSampleSize(t *testing.T) {
+    health := newTestHealth(t, map[string]string{"BAT0/type": "Battery",
+        "BAT0/energy_full": "45000000", "BAT0/energy_full_design": "50000000"})
+    // A day old sample for every day of the trend period.
+    day := time.Now().Add(-24 * time.Hour)
+    health.samples = make([]HealthSample, healthSize)
+    for i := range health.samples {
+        health.samples[i].Time = day.Add(-time.Duration(healthSize-1-i) * 24 * time.Hour).Unix()
+    }
+    first := health.samples[1].Time
+    if err := health.sample(); err != nil {
+        t.Fatalf("Got error: %v", err)
+    }
+    if len(health.samples) != healthSize {
+        t.Errorf("Got %d samples, want %d", len(health.samples), healthSize)
+    }
+    if health.samples[0].Time != first {
+        t.Errorf("Got first sample %+v, want the oldest one dropped", health.samples[0])
+    }
+    if last := health.samples[healthSize-1]; last.Full != 45000000 {
+        t.Errorf("Got last sample %+v", last)
+    }
+}
+
+func TestTrend(t *testing.T) {
+    const design = 1000
+    now := time.Now()
+    var samples []HealthSample
+    // 1% wear per 30 days over 90 days, preceded by a sample outside the
+    // trend period that must be ignored.
+    samples = append(samples, HealthSample{Time: now.Add(-healthTrendPeriod - healthTrendUnit).Unix(),
+        Full: 0, FullDesign: design})
+    for day := 90; day >= 0; day -= 10 {
+        wear := float64(90-day) / 30
+        samples = append(samples, HealthSample{Time: now.Add(-time.Duration(day) * 24 * time.Hour).Unix(),
+            Full: int64(design * (100 - wear) / 100), FullDesign: design})
+    }
+    health := &batteryHealth{samples: samples}
+    if got := health.trend(); got < 0.9 || got > 1.1 {
+        t.Errorf("Got trend %v, want about 1", got)
+    }
+}
\ No newline at end of file
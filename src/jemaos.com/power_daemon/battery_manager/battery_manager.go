@@ -0,0 +1,202 @@
+package battery_manager
+
+import (
+    "context"
+    "log"
+    "math"
+    "strconv"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // D-Bus signal names for power supply updates and resume.
+    sigPowerSupplyPoll = "PowerSupplyPoll"
+    sigSuspendDone     = "SuspendDone"
+
+    // Board hook functions run when the battery crosses a threshold.
+    hookBatteryLow       = "battery_low"
+    hookBatteryCritical  = "battery_critical"
+    hookBatteryFull      = "battery_full"
+    hookChargerConnected = "charger_connected"
+
+    // Default thresholds in percent.
+    defaultLowPercent        = 15.0
+    defaultCriticalPercent   = 5.0
+    defaultFullPercent       = 100.0
+    defaultHysteresisPercent = 2.0
+
+    // Timeout for hook execution in milliseconds.
+    execTimeout = 2000
+)
+
+// threshold fires a hook once when the battery crosses a level and re-arms
+// after it moved back past the level by the hysteresis.
+type threshold struct {
+    hook    string
+    percent float64
+    // below is set for thresholds crossed while discharging.
+    below   bool
+    fired   bool
+}
+
+// update reports whether the hook must fire for the current percentage. The
+// hook only fires while active, e.g. while discharging.
+func (t *threshold) update(percent, hysteresis float64, active bool) bool {
+    crossed, rearm := percent <= t.percent, percent >= t.percent+hysteresis
+    if !t.below {
+        crossed, rearm = percent >= t.percent, percent <= t.percent-hysteresis
+    }
+    if t.fired && rearm {
+        t.fired = false
+    }
+    if t.fired || !active || !crossed {
+        return false
+    }
+    t.fired = true
+    return true
+}
+
+// BatteryManager tracks the battery state from powerd and runs the board
+// battery threshold hooks.
+type BatteryManager struct {
+    ctx        context.Context
+    runner     *hookutil.Runner
+    hysteresis float64
+    low        *threshold
+    critical   *threshold
+    full       *threshold
+    // Last decoded power supply state; nil until the first poll.
+    props      *pmpb.PowerSupplyProperties
+    health     *batteryHealth
+}
+
+// percentOr returns value, or def if value is not configured.
+func percentOr(value, def float64) float64 {
+    if value <= 0 {
+        return def
+    }
+    return value
+}
+
+// NewBatteryManager initializes a new BatteryManager instance.
+// The battery health is sampled from the power_supply tree at root, normally
+// config.PathPowerSupply.
+func NewBatteryManager(ctx context.Context, cfg *config.Config, runner *hookutil.Runner, root string) *BatteryManager {
+    return &BatteryManager{ctx, runner,
+        percentOr(cfg.Battery.HysteresisPercent, defaultHysteresisPercent),
+        &threshold{hookBatteryLow, percentOr(cfg.Battery.LowPercent, defaultLowPercent), true, false},
+        &threshold{hookBatteryCritical, percentOr(cfg.Battery.CriticalPercent, defaultCriticalPercent), true, false},
+        &threshold{hookBatteryFull, percentOr(cfg.Battery.FullPercent, defaultFullPercent), false, false},
+        nil, newBatteryHealth(root)}
+}
+
+// Percent returns the last known battery percentage.
+func (manager *BatteryManager) Percent() float64 {
+    return manager.props.GetBatteryPercent()
+}
+
+// OnBattery reports whether the system runs on battery.
+func (manager *BatteryManager) OnBattery() bool {
+    return manager.props != nil &&
+        manager.props.GetExternalPower() == pmpb.PowerSupplyProperties_DISCONNECTED
+}
+
+// TimeToEmpty returns the estimated time until the battery is empty.
+func (manager *BatteryManager) TimeToEmpty() time.Duration {
+    return time.Duration(manager.props.GetBatteryTimeToEmptySec()) * time.Second
+}
+
+// IsLow reports whether the battery is at or below the low threshold.
+func (manager *BatteryManager) IsLow() bool {
+    return manager.low.fired
+}
+
+// runHook runs a battery hook with the current state in its environment.
+func (manager *BatteryManager) runHook(hook string) {
+    props := manager.props
+    log.Printf("Battery %.1f%%, %s, run hook %s", props.GetBatteryPercent(), props.GetBatteryState().String(), hook)
+    env := []string{
+        "POWERD_BATTERY_PERCENT=" + strconv.FormatFloat(props.GetBatteryPercent(), 'f', 1, 64),
+        "POWERD_BATTERY_STATE=" + props.GetBatteryState().String(),
+        "POWERD_POWER_SOURCE=" + props.GetExternalPower().String(),
+        "POWERD_TIME_TO_EMPTY_SEC=" + strconv.FormatInt(props.GetBatteryTimeToEmptySec(), 10),
+    }
+    ctx, cancel := context.WithTimeout(manager.ctx, execTimeout*time.Millisecond)
+    defer cancel()
+    manager.runner.Run(ctx, hook, env)
+}
+
+// HandlePowerSupplyPoll processes the PowerSupplyPoll signal.
+func (manager *BatteryManager) HandlePowerSupplyPoll(signal *dbus.Signal) error {
+    props := &pmpb.PowerSupplyProperties{}
+    if err := dbusutil.DecodeSignal(signal, props); err != nil {
+        return err
+    }
+    prev := manager.props
+    manager.props = props
+    if props.GetBatteryState() == pmpb.PowerSupplyProperties_NOT_PRESENT {
+        return nil
+    }
+
+    percent := props.GetBatteryPercent()
+    discharging := props.GetBatteryState() == pmpb.PowerSupplyProperties_DISCHARGING
+    connected := props.GetExternalPower() != pmpb.PowerSupplyProperties_DISCONNECTED
+    if prev != nil && prev.GetExternalPower() == pmpb.PowerSupplyProperties_DISCONNECTED && connected {
+        manager.runHook(hookChargerConnected)
+    }
+    if manager.critical.update(percent, manager.hysteresis, discharging) {
+        // Reaching critical implies low, do not report low afterwards.
+        manager.low.fired = true
+        manager.runHook(hookBatteryCritical)
+    }
+    if manager.low.update(percent, manager.hysteresis, discharging) {
+        manager.runHook(hookBatteryLow)
+    }
+    // powerd may report a full battery below 100%.
+    fullPercent := percent
+    if props.GetBatteryState() == pmpb.PowerSupplyProperties_FULL {
+        fullPercent = math.Max(percent, manager.full.percent)
+    }
+    if manager.full.update(fullPercent, manager.hysteresis, connected) {
+        manager.runHook(hookBatteryFull)
+    }
+    return nil
+}
+
+// Register registers the battery manager with the signal server.
+func (manager *BatteryManager) Register(sigServer *dbusutil.SignalServer) error {
+    handler := func(sig *dbus.Signal) error {
+        return manager.HandlePowerSupplyPoll(sig)
+    }
+    resumeHandler := func(sig *dbus.Signal) error {
+        return manager.health.sampleResume()
+    }
+    sigServer.RegisterSignalHandler(sigPowerSupplyPoll, handler)
+    sigServer.RegisterSignalHandler(sigSuspendDone, resumeHandler)
+    sigServer.RegisterTicker(healthCheckInterval, manager.health.sampleDaily)
+    log.Println("Battery manager registered")
+    return nil
+}
+
+// GetBatteryHealth implements the GetBatteryHealth D-Bus method, see
+// batteryHealth.get.
+func (manager *BatteryManager) GetBatteryHealth(count uint32) (string, *dbus.Error) {
+    return manager.health.get(count)
+}
+
+// RegisterMethods registers the battery D-Bus methods with the service server.
+func (manager *BatteryManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetBatteryHealth, manager.GetBatteryHealth)
+}
+
+// UnRegister unregisters the battery manager from the signal server.
+func (manager *BatteryManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering battery manager")
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,239 @@
+package battery_saver_manager
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/backlight_manager"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+    "jemaos.com/power_daemon/sysfsutil"
+)
+
+const (
+    // D-Bus signal name for battery saver mode changes.
+    sigBatterySaverModeStateChanged = "BatterySaverModeStateChanged"
+
+    // D-Bus method name for querying the battery saver mode.
+    methdGetBatterySaverModeState = "GetBatterySaverModeState"
+
+    // Owner of the screen brightness cap.
+    ownerBatterySaver = "battery_saver"
+
+    // Board hook functions run when battery saver mode is switched on or off.
+    hookBatterySaverOn  = "battery_saver_on"
+    hookBatterySaverOff = "battery_saver_off"
+
+    // File keeping the state replaced by the savings, relative to the state
+    // directory, so it is restored after a daemon restart too.
+    fileBatterySaver = "battery_saver.json"
+
+    // Timeout for hook execution in milliseconds.
+    execTimeout = 2000
+)
+
+// batterySaverState is the state replaced by the savings, persisted while
+// they are applied.
+type batterySaverState struct {
+    Applied        bool              `json:"applied"`
+    // Screen brightness and keyboard backlight levels from before the savings.
+    ScreenBefore   float64           `json:"screen_before"`
+    KeyboardBefore float64           `json:"keyboard_before"`
+    // Tunables written while battery saver is on, with the values they replaced.
+    Changes        sysfsutil.Changes `json:"changes"`
+}
+
+// BatterySaverManager applies the configured savings while powerd's battery
+// saver mode is on and restores the previous state when it ends.
+type BatterySaverManager struct {
+    ctx        context.Context
+    obj        dbus.BusObject
+    runner     *hookutil.Runner
+    backlight  *backlight_manager.ScreenBrightnessManager
+    cfg        config.BatterySaverConfig
+    state_path string
+    enabled    bool
+    state      batterySaverState
+}
+
+// NewBatterySaverManager initializes a new BatterySaverManager instance.
+func NewBatterySaverManager(ctx context.Context, conn *dbus.Conn, cfg *config.Config, runner *hookutil.Runner,
+    backlight *backlight_manager.ScreenBrightnessManager) *BatterySaverManager {
+    manager := &BatterySaverManager{ctx: ctx, obj: dbusutil.GetPMObject(conn), runner: runner,
+        backlight: backlight, cfg: cfg.BatterySaver,
+        state_path: filepath.Join(config.PathStateDir, fileBatterySaver)}
+    if buf, err := ioutil.ReadFile(manager.state_path); err == nil {
+        if err := json.Unmarshal(buf, &manager.state); err != nil {
+            // Older versions kept only the tunable changes.
+            if json.Unmarshal(buf, &manager.state.Changes) != nil {
+                log.Printf("Parse %s error: %v", manager.state_path, err)
+            }
+        }
+    }
+    return manager
+}
+
+// runHook runs a battery saver hook with a timeout.
+func (manager *BatterySaverManager) runHook(hook string, cause string) {
+    ctx, cancel := context.WithTimeout(manager.ctx, execTimeout*time.Millisecond)
+    defer cancel()
+    manager.runner.Run(ctx, hook, []string{"POWERD_BATTERY_SAVER_CAUSE=" + cause})
+}
+
+// save persists the replaced state, or removes the file once it is restored.
+func (manager *BatterySaverManager) save() error {
+    if !manager.state.Applied && len(manager.state.Changes) == 0 {
+        if err := os.Remove(manager.state_path); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        return nil
+    }
+    buf, err := json.Marshal(&manager.state)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(manager.state_path), 0755); err != nil {
+        return err
+    }
+    tmp := manager.state_path + ".tmp"
+    if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, manager.state_path)
+}
+
+// applyTunables writes the configured tunables and records the values they
+// replaced.
+func (manager *BatterySaverManager) applyTunables() {
+    patterns := make([]string, 0, len(manager.cfg.Tunables))
+    for pattern := range manager.cfg.Tunables {
+        patterns = append(patterns, pattern)
+    }
+    sort.Strings(patterns)
+    for _, pattern := range patterns {
+        paths, err := filepath.Glob(pattern)
+        if err != nil {
+            log.Printf("Tunable %s error: %v", pattern, err)
+            continue
+        }
+        for _, path := range paths {
+            if _, err := manager.state.Changes.Set(path, manager.cfg.Tunables[pattern]); err != nil {
+                log.Printf("Set tunable %s error: %v", path, err)
+            }
+        }
+    }
+}
+
+// revertTunables restores the values replaced by the tunables.
+func (manager *BatterySaverManager) revertTunables() {
+    if err := manager.state.Changes.Revert(); err != nil {
+        log.Printf("Revert tunables error: %v", err)
+    }
+}
+
+// setEnabled applies or restores the savings when the mode changes.
+func (manager *BatterySaverManager) setEnabled(enabled bool, cause string) {
+    if enabled == manager.enabled {
+        return
+    }
+    manager.enabled = enabled
+    log.Printf("Battery saver mode %v, cause %s", enabled, cause)
+
+    if enabled {
+        // After a daemon restart with the savings applied, the current levels
+        // are the saved ones; the persisted levels are restored instead.
+        restart := manager.state.Applied
+        if manager.cfg.ScreenBrightnessPercent > 0 {
+            if err := manager.backlight.CapScreenBrightness(ownerBatterySaver, manager.cfg.ScreenBrightnessPercent); err != nil {
+                log.Printf("Cap screen brightness error: %v", err)
+            }
+            if restart {
+                manager.backlight.SetUncappedScreenBrightness(manager.state.ScreenBefore)
+            } else {
+                manager.state.ScreenBefore = manager.backlight.UncappedScreenBrightness()
+            }
+        }
+        if manager.cfg.KeyboardBacklightOff {
+            if err := manager.backlight.KeyboardBacklightOff(); err != nil {
+                log.Printf("Switch keyboard backlight off error: %v", err)
+            }
+            if restart {
+                manager.backlight.SetKeyboardBrightnessBeforeOff(manager.state.KeyboardBefore)
+            } else {
+                manager.state.KeyboardBefore = manager.backlight.KeyboardBrightnessBeforeOff()
+            }
+        }
+        manager.state.Applied = true
+        manager.applyTunables()
+        if err := manager.save(); err != nil {
+            log.Printf("Save battery saver state error: %v", err)
+        }
+        manager.runHook(hookBatterySaverOn, cause)
+        return
+    }
+
+    // Undo in the reverse order.
+    manager.runHook(hookBatterySaverOff, cause)
+    manager.revertTunables()
+    manager.state = batterySaverState{}
+    if err := manager.save(); err != nil {
+        log.Printf("Save battery saver state error: %v", err)
+    }
+    if err := manager.backlight.KeyboardBacklightOn(); err != nil {
+        log.Printf("Restore keyboard backlight error: %v", err)
+    }
+    if err := manager.backlight.UncapScreenBrightness(ownerBatterySaver); err != nil {
+        log.Printf("Restore screen brightness error: %v", err)
+    }
+}
+
+// HandleBatterySaverModeStateChanged processes the BatterySaverModeStateChanged signal.
+func (manager *BatterySaverManager) HandleBatterySaverModeStateChanged(signal *dbus.Signal) error {
+    state := &pmpb.BatterySaverModeState{}
+    if err := dbusutil.DecodeSignal(signal, state); err != nil {
+        return err
+    }
+    manager.setEnabled(state.GetEnabled(), state.GetCause().String())
+    return nil
+}
+
+// Register queries the initial battery saver mode and registers the battery
+// saver manager with the signal server. Tunables left over from a previous run
+// are reverted if the mode is off.
+func (manager *BatterySaverManager) Register(sigServer *dbusutil.SignalServer) error {
+    state := &pmpb.BatterySaverModeState{}
+    if err := dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(methdGetBatterySaverModeState), nil, state); err != nil {
+        log.Printf("Get battery saver mode error: %v", err)
+    } else {
+        manager.setEnabled(state.GetEnabled(), state.GetCause().String())
+    }
+    if !manager.enabled && (manager.state.Applied || len(manager.state.Changes) > 0) {
+        manager.revertTunables()
+        manager.state = batterySaverState{}
+        if err := manager.save(); err != nil {
+            log.Printf("Save battery saver state error: %v", err)
+        }
+    }
+
+    handler := func(sig *dbus.Signal) error {
+        return manager.HandleBatterySaverModeStateChanged(sig)
+    }
+    sigServer.RegisterSignalHandler(sigBatterySaverModeStateChanged, handler)
+    log.Println("Battery saver manager registered")
+    return nil
+}
+
+// UnRegister unregisters the battery saver manager from the signal server.
+func (manager *BatterySaverManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering battery saver manager")
+    return nil
+}
\ No newline at end of file
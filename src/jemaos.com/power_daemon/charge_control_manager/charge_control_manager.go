@@ -0,0 +1,350 @@
+package charge_control_manager
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+)
+
+const (
+    // D-Bus signal name for power supply updates.
+    sigPowerSupplyPoll = "PowerSupplyPoll"
+
+    // D-Bus method names of the charge control API.
+    methdGetChargeControl = "GetChargeControl"
+    methdSetChargeLimits  = "SetChargeLimits"
+    methdChargeToFullOnce = "ChargeToFullOnce"
+
+    // Attributes of a power supply in sysfs.
+    attrType            = "type"
+    attrStartThreshold  = "charge_control_start_threshold"
+    attrEndThreshold    = "charge_control_end_threshold"
+    attrChargeBehaviour = "charge_behaviour"
+
+    // Values of the charge_behaviour attribute.
+    behaviourAuto    = "auto"
+    behaviourInhibit = "inhibit-charge"
+
+    // File keeping the charge control settings, relative to the state directory.
+    fileChargeControl = "charge_control.json"
+
+    // Default charge limits in percent.
+    defaultStartPercent = 75
+    defaultEndPercent   = 80
+
+    // Driver default thresholds in percent, restored while charge control is
+    // off. Drivers such as thinkpad_acpi reject a start threshold of 100.
+    driverStartPercent = 0
+    driverEndPercent   = 100
+)
+
+// ChargeControlState holds the charge control settings, persisted in the
+// daemon's state directory.
+type ChargeControlState struct {
+    Enabled      bool `json:"enabled"`
+    StartPercent int  `json:"start_percent"`
+    EndPercent   int  `json:"end_percent"`
+    // FullOnce lets the battery charge to 100% until external power is unplugged.
+    FullOnce bool `json:"full_once"`
+}
+
+// ChargeControlManager limits battery charging through the power_supply sysfs
+// class, using the charge thresholds where the driver supports them and
+// charge_behaviour otherwise. Its D-Bus methods are called from godbus
+// goroutines, so all state is guarded by mutex.
+type ChargeControlManager struct {
+    ctx        context.Context
+    root       string
+    state_path string
+    mutex      sync.Mutex
+    state      ChargeControlState
+    // Last known external power state and charge, from PowerSupplyPoll.
+    connected  bool
+    percent    float64
+    // write writes a sysfs attribute, normally writeFile.
+    write      func(path, value string) error
+}
+
+// NewChargeControlManager initializes a new ChargeControlManager instance
+// working on the power_supply tree at root, normally config.PathPowerSupply.
+func NewChargeControlManager(ctx context.Context, cfg *config.Config, root string) *ChargeControlManager {
+    manager := &ChargeControlManager{ctx: ctx, root: root,
+        state_path: filepath.Join(config.PathStateDir, fileChargeControl), write: writeFile}
+    manager.state = ChargeControlState{cfg.ChargeControl.Enabled,
+        cfg.ChargeControl.StartPercent, cfg.ChargeControl.EndPercent, false}
+    if manager.state.StartPercent <= 0 || manager.state.EndPercent <= 0 {
+        manager.state.StartPercent, manager.state.EndPercent = defaultStartPercent, defaultEndPercent
+    }
+    if buf, err := ioutil.ReadFile(manager.state_path); err == nil {
+        if err := json.Unmarshal(buf, &manager.state); err != nil {
+            log.Printf("Parse %s error: %v", manager.state_path, err)
+        }
+    }
+    return manager
+}
+
+// writeFile writes value to the file at path.
+func writeFile(path, value string) error {
+    return ioutil.WriteFile(path, []byte(value), 0644)
+}
+
+// readAttr reads a sysfs attribute of a power supply.
+func (manager *ChargeControlManager) readAttr(supply, attr string) (string, error) {
+    buf, err := ioutil.ReadFile(filepath.Join(manager.root, supply, attr))
+    return strings.TrimSpace(string(buf)), err
+}
+
+// writeAttr writes a sysfs attribute of a power supply.
+func (manager *ChargeControlManager) writeAttr(supply, attr, value string) error {
+    log.Printf("Set %s/%s to %s", supply, attr, value)
+    return manager.write(filepath.Join(manager.root, supply, attr), value)
+}
+
+// hasAttr reports whether a power supply has a sysfs attribute.
+func (manager *ChargeControlManager) hasAttr(supply, attr string) bool {
+    _, err := os.Stat(filepath.Join(manager.root, supply, attr))
+    return err == nil
+}
+
+// batteries returns the names of the batteries in the power_supply tree.
+func (manager *ChargeControlManager) batteries() []string {
+    entries, err := ioutil.ReadDir(manager.root)
+    if err != nil {
+        log.Printf("List %s error: %v", manager.root, err)
+        return nil
+    }
+    var names []string
+    for _, entry := range entries {
+        if value, err := manager.readAttr(entry.Name(), attrType); err == nil && value == "Battery" {
+            names = append(names, entry.Name())
+        }
+    }
+    return names
+}
+
+// readThreshold reads a charge threshold of a battery, or -1 if it is missing
+// or unreadable.
+func (manager *ChargeControlManager) readThreshold(battery, attr string) int {
+    value, err := manager.readAttr(battery, attr)
+    if err != nil {
+        return -1
+    }
+    percent, err := strconv.Atoi(value)
+    if err != nil {
+        return -1
+    }
+    return percent
+}
+
+// setThresholds writes the charge thresholds of a battery that differ from
+// the targets. The kernel rejects a start threshold above the end threshold,
+// so the order depends on the direction of the change.
+func (manager *ChargeControlManager) setThresholds(battery string, start, end int) error {
+    currentStart := manager.readThreshold(battery, attrStartThreshold)
+    currentEnd := manager.readThreshold(battery, attrEndThreshold)
+    attrs := []string{attrStartThreshold, attrEndThreshold}
+    values := []int{start, end}
+    currents := []int{currentStart, currentEnd}
+    if start >= currentEnd {
+        attrs[0], attrs[1] = attrs[1], attrs[0]
+        values[0], values[1] = values[1], values[0]
+        currents[0], currents[1] = currents[1], currents[0]
+    }
+    for i, attr := range attrs {
+        if values[i] == currents[i] || !manager.hasAttr(battery, attr) {
+            continue
+        }
+        if err := manager.writeAttr(battery, attr, strconv.Itoa(values[i])); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// setBehaviour emulates the thresholds with charge_behaviour: charging is
+// inhibited at the end threshold and allowed again at the start threshold.
+func (manager *ChargeControlManager) setBehaviour(battery string, limit bool) error {
+    current, err := manager.readAttr(battery, attrChargeBehaviour)
+    if err != nil {
+        return err
+    }
+    behaviour := behaviourAuto
+    if limit {
+        switch {
+        case manager.percent >= float64(manager.state.EndPercent):
+            behaviour = behaviourInhibit
+        case manager.percent > float64(manager.state.StartPercent) && strings.Contains(current, "["+behaviourInhibit+"]"):
+            // Between the thresholds, keep the current behaviour.
+            return nil
+        }
+    }
+    // The attribute lists all behaviours with the active one in brackets.
+    if strings.Contains(current, "["+behaviour+"]") {
+        return nil
+    }
+    return manager.writeAttr(battery, attrChargeBehaviour, behaviour)
+}
+
+// apply applies the settings to every battery. Called with mutex held.
+func (manager *ChargeControlManager) apply() error {
+    limit := manager.state.Enabled && !manager.state.FullOnce
+    start, end := driverStartPercent, driverEndPercent
+    if limit {
+        start, end = manager.state.StartPercent, manager.state.EndPercent
+    }
+    var errs []error
+    for _, battery := range manager.batteries() {
+        var err error
+        if manager.hasAttr(battery, attrEndThreshold) {
+            err = manager.setThresholds(battery, start, end)
+        } else if manager.hasAttr(battery, attrChargeBehaviour) {
+            err = manager.setBehaviour(battery, limit)
+        } else {
+            continue
+        }
+        if err != nil {
+            errs = append(errs, fmt.Errorf("%s: %w", battery, err))
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// save persists the settings. Called with mutex held.
+func (manager *ChargeControlManager) save() error {
+    buf, err := json.Marshal(&manager.state)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(manager.state_path), 0755); err != nil {
+        return err
+    }
+    tmp := manager.state_path + ".tmp"
+    if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, manager.state_path)
+}
+
+// update saves and applies the settings. Called with mutex held.
+func (manager *ChargeControlManager) update() error {
+    if err := manager.save(); err != nil {
+        log.Printf("Save charge control error: %v", err)
+    }
+    return manager.apply()
+}
+
+// stateJSON returns the settings as JSON. Called with mutex held.
+func (manager *ChargeControlManager) stateJSON() (string, *dbus.Error) {
+    buf, err := json.Marshal(&manager.state)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
+
+// GetChargeControl implements the GetChargeControl D-Bus method.
+func (manager *ChargeControlManager) GetChargeControl() (string, *dbus.Error) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    return manager.stateJSON()
+}
+
+// SetChargeLimits implements the SetChargeLimits D-Bus method. Limits of 0 and
+// 100 disable charge control.
+func (manager *ChargeControlManager) SetChargeLimits(start, end uint32) (string, *dbus.Error) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    if start >= end || end > 100 {
+        return "", dbus.MakeFailedError(fmt.Errorf("invalid charge limits %d-%d", start, end))
+    }
+    manager.state.Enabled = !(start == 0 && end == 100)
+    if manager.state.Enabled {
+        manager.state.StartPercent, manager.state.EndPercent = int(start), int(end)
+    }
+    if err := manager.update(); err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return manager.stateJSON()
+}
+
+// ChargeToFullOnce implements the ChargeToFullOnce D-Bus method. It lifts the
+// limits until external power is unplugged.
+func (manager *ChargeControlManager) ChargeToFullOnce() (string, *dbus.Error) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    manager.state.FullOnce = true
+    if err := manager.update(); err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return manager.stateJSON()
+}
+
+// HandlePowerSupplyPoll resets the charge to full override after unplug and
+// drives charge_behaviour based on the battery charge.
+func (manager *ChargeControlManager) HandlePowerSupplyPoll(signal *dbus.Signal) error {
+    props := &pmpb.PowerSupplyProperties{}
+    if err := dbusutil.DecodeSignal(signal, props); err != nil {
+        return err
+    }
+    return manager.powerSupplyChanged(props.GetExternalPower() != pmpb.PowerSupplyProperties_DISCONNECTED,
+        props.GetBatteryPercent())
+}
+
+// powerSupplyChanged records the external power state and the battery charge
+// and applies the settings.
+func (manager *ChargeControlManager) powerSupplyChanged(connected bool, percent float64) error {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    unplugged := manager.connected && !connected
+    manager.connected = connected
+    manager.percent = percent
+    if unplugged && manager.state.FullOnce {
+        log.Println("External power unplugged, reset charge to full override")
+        manager.state.FullOnce = false
+        return manager.update()
+    }
+    return manager.apply()
+}
+
+// Register applies the settings and registers the charge control manager
+// with the signal server.
+func (manager *ChargeControlManager) Register(sigServer *dbusutil.SignalServer) error {
+    manager.mutex.Lock()
+    if err := manager.apply(); err != nil {
+        log.Printf("Apply charge control error: %v", err)
+    }
+    manager.mutex.Unlock()
+
+    handler := func(sig *dbus.Signal) error {
+        return manager.HandlePowerSupplyPoll(sig)
+    }
+    sigServer.RegisterSignalHandler(sigPowerSupplyPoll, handler)
+    log.Println("Charge control manager registered")
+    return nil
+}
+
+// RegisterMethods registers the charge control D-Bus methods with the service server.
+func (manager *ChargeControlManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetChargeControl, manager.GetChargeControl)
+    service.RegisterMethod(methdSetChargeLimits, manager.SetChargeLimits)
+    service.RegisterMethod(methdChargeToFullOnce, manager.ChargeToFullOnce)
+}
+
+// UnRegister unregisters the charge control manager from the signal server.
+func (manager *ChargeControlManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering charge control manager")
+    return nil
+}
\ No newline at end of file
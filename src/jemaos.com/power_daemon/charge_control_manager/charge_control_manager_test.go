@@ -0,0 +1,157 @@
+package charge_control_manager
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/sysfsutil/sysfstest"
+)
+
+// newTestManager returns a manager working on a fake power_supply tree with
+// a battery BAT0 with the given attributes, and a temporary state directory.
+// The writes to sysfs are recorded as "attr=value", in order.
+func newTestManager(t *testing.T, attrs map[string]string, state ChargeControlState) (*ChargeControlManager, *[]string) {
+    root := t.TempDir()
+    files := map[string]string{"BAT0/" + attrType: "Battery", "AC/" + attrType: "Mains",
+        "AC/" + attrChargeBehaviour: "[auto] inhibit-charge"}
+    for attr, value := range attrs {
+        files["BAT0/"+attr] = value
+    }
+    sysfstest.WriteFiles(t, root, files)
+    sysfstest.StateDir(t)
+    manager := NewChargeControlManager(context.Background(), &config.Config{}, root)
+    manager.state = state
+    writes := new([]string)
+    manager.write = func(path, value string) error {
+        *writes = append(*writes, filepath.Base(path)+"="+value)
+        return writeFile(path, value)
+    }
+    return manager, writes
+}
+
+func TestSetThresholds(t *testing.T) {
+    tests := []struct {
+        name       string
+        attrs      map[string]string
+        start, end int
+        want       []string
+    }{
+        {"raise", map[string]string{attrStartThreshold: "40", attrEndThreshold: "50"}, 75, 80,
+            []string{attrEndThreshold + "=80", attrStartThreshold + "=75"}},
+        {"lower", map[string]string{attrStartThreshold: "75", attrEndThreshold: "80"}, 40, 50,
+            []string{attrStartThreshold + "=40", attrEndThreshold + "=50"}},
+        {"unchanged", map[string]string{attrStartThreshold: "75", attrEndThreshold: "80"}, 75, 80,
+            nil},
+        {"end unchanged", map[string]string{attrStartThreshold: "60", attrEndThreshold: "80"}, 75, 80,
+            []string{attrStartThreshold + "=75"}},
+        {"restore defaults", map[string]string{attrStartThreshold: "75", attrEndThreshold: "80"}, driverStartPercent, driverEndPercent,
+            []string{attrStartThreshold + "=0", attrEndThreshold + "=100"}},
+        {"end only", map[string]string{attrEndThreshold: "80"}, driverStartPercent, driverEndPercent,
+            []string{attrEndThreshold + "=100"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            manager, writes := newTestManager(t, test.attrs, ChargeControlState{})
+            if err := manager.setThresholds("BAT0", test.start, test.end); err != nil {
+                t.Fatalf("Got error: %v", err)
+            }
+            if !reflect.DeepEqual(*writes, test.want) {
+                t.Errorf("Got writes %v, want %v", *writes, test.want)
+            }
+        })
+    }
+}
+
+func TestApplyBehaviourFallback(t *testing.T) {
+    tests := []struct {
+        name    string
+        attrs   map[string]string
+        state   ChargeControlState
+        percent float64
+        want    []string
+    }{
+        {"inhibit at end", map[string]string{attrChargeBehaviour: "[auto] inhibit-charge"},
+            ChargeControlState{true, 75, 80, false}, 80, []string{attrChargeBehaviour + "=" + behaviourInhibit}},
+        {"keep between limits", map[string]string{attrChargeBehaviour: "auto [inhibit-charge]"},
+            ChargeControlState{true, 75, 80, false}, 78, nil},
+        {"auto at start", map[string]string{attrChargeBehaviour: "auto [inhibit-charge]"},
+            ChargeControlState{true, 75, 80, false}, 75, []string{attrChargeBehaviour + "=" + behaviourAuto}},
+        {"charging below end", map[string]string{attrChargeBehaviour: "[auto] inhibit-charge"},
+            ChargeControlState{true, 75, 80, false}, 78, nil},
+        {"disabled", map[string]string{attrChargeBehaviour: "auto [inhibit-charge]"},
+            ChargeControlState{false, 75, 80, false}, 90, []string{attrChargeBehaviour + "=" + behaviourAuto}},
+        {"full once", map[string]string{attrChargeBehaviour: "auto [inhibit-charge]"},
+            ChargeControlState{true, 75, 80, true}, 90, []string{attrChargeBehaviour + "=" + behaviourAuto}},
+        {"thresholds preferred", map[string]string{attrChargeBehaviour: "[auto] inhibit-charge",
+            attrStartThreshold: "0", attrEndThreshold: "100"},
+            ChargeControlState{true, 75, 80, false}, 90, []string{attrStartThreshold + "=75", attrEndThreshold + "=80"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            // The AC supply is left alone.
+            manager, writes := newTestManager(t, test.attrs, test.state)
+            manager.percent = test.percent
+            if err := manager.apply(); err != nil {
+                t.Fatalf("Got error: %v", err)
+            }
+            if !reflect.DeepEqual(*writes, test.want) {
+                t.Errorf("Got writes %v, want %v", *writes, test.want)
+            }
+        })
+    }
+}
+
+func TestFullOnceResetOnUnplug(t *testing.T) {
+    tests := []struct {
+        name         string
+        connected    bool
+        nowConnected bool
+        wantFullOnce bool
+        want         []string
+    }{
+        {"still connected", true, true, true, nil},
+        {"unplugged", true, false, false, []string{attrStartThreshold + "=75", attrEndThreshold + "=80"}},
+        {"already disconnected", false, false, true, nil},
+        {"plugged in", false, true, true, nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            manager, writes := newTestManager(t, map[string]string{attrStartThreshold: "0", attrEndThreshold: "100"},
+                ChargeControlState{true, 75, 80, true})
+            manager.connected = test.connected
+            if err := manager.powerSupplyChanged(test.nowConnected, 90); err != nil {
+                t.Fatalf("Got error: %v", err)
+            }
+            if !reflect.DeepEqual(*writes, test.want) {
+                t.Errorf("Got writes %v, want %v", *writes, test.want)
+            }
+            if manager.state.FullOnce != test.wantFullOnce {
+                t.Errorf("Got full once %v, want %v", manager.state.FullOnce, test.wantFullOnce)
+            }
+            if test.wantFullOnce {
+                return
+            }
+            // The reset is persisted.
+            buf, err := ioutil.ReadFile(manager.state_path)
+            if err != nil {
+                t.Fatal(err)
+            }
+            var saved ChargeControlState
+            if err := json.Unmarshal(buf, &saved); err != nil {
+                t.Fatal(err)
+            }
+            if saved != manager.state {
+                t.Errorf("Got saved state %+v, want %+v", saved, manager.state)
+            }
+            if _, err := os.Stat(manager.state_path + ".tmp"); !os.IsNotExist(err) {
+                t.Errorf("Temporary state file left behind, stat error: %v", err)
+            }
+        })
+    }
+}
\ No newline at end of file
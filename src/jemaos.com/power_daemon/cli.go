@@ -0,0 +1,154 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/dbusutil"
+)
+
+// command describes a CLI command that queries the running daemon over D-Bus.
+type command struct {
+    method string
+    usage  string
+    // args converts the command line arguments into the method arguments.
+    args   func([]string) ([]interface{}, error)
+}
+
+// noArgs accepts no arguments.
+func noArgs(args []string) ([]interface{}, error) {
+    if len(args) != 0 {
+        return nil, fmt.Errorf("unexpected arguments %v", args)
+    }
+    return nil, nil
+}
+
+// countArg parses an optional record count argument.
+func countArg(args []string) ([]interface{}, error) {
+    if len(args) == 0 {
+        return []interface{}{uint32(0)}, nil
+    }
+    count, err := strconv.ParseUint(args[0], 10, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid count %q", args[0])
+    }
+    return []interface{}{uint32(count)}, nil
+}
+
+// limitsArgs parses the start and end charge limits in percent.
+func limitsArgs(args []string) ([]interface{}, error) {
+    if len(args) != 2 {
+        return nil, fmt.Errorf("expected <start> <end>, got %v", args)
+    }
+    limits := make([]interface{}, 0, 2)
+    for _, arg := range args {
+        percent, err := strconv.ParseUint(arg, 10, 32)
+        if err != nil {
+            return nil, fmt.Errorf("invalid percent %q", arg)
+        }
+        limits = append(limits, uint32(percent))
+    }
+    return limits, nil
+}
+
+// nameArg parses a single name argument.
+func nameArg(args []string) ([]interface{}, error) {
+    if len(args) != 1 {
+        return nil, fmt.Errorf("expected <name>, got %v", args)
+    }
+    return []interface{}{args[0]}, nil
+}
+
+// hookHistoryArgs parses an optional hook name and record count.
+func hookHistoryArgs(args []string) ([]interface{}, error) {
+    if len(args) > 2 {
+        return nil, fmt.Errorf("expected [hook] [count], got %v", args)
+    }
+    hook := ""
+    if len(args) > 0 {
+        if _, err := strconv.ParseUint(args[0], 10, 32); err != nil {
+            hook, args = args[0], args[1:]
+        }
+    }
+    if len(args) > 1 {
+        return nil, fmt.Errorf("expected [hook] [count], got %v", args)
+    }
+    count, err := countArg(args)
+    if err != nil {
+        return nil, err
+    }
+    return append([]interface{}{hook}, count...), nil
+}
+
+// commands maps CLI command names to their daemon D-Bus methods.
+var commands = map[string]command{
+    "battery_health":       {"GetBatteryHealth", "[count]  show the battery wear and the last health samples", countArg},
+    "charge_control":       {"GetChargeControl", "  show the battery charge limits", noArgs},
+    "charge_full_once":     {"ChargeToFullOnce", "  charge to 100% until external power is unplugged", noArgs},
+    "charge_limits":        {"SetChargeLimits", "<start> <end>  set the battery charge limits, 0 100 disables them", limitsArgs},
+    "hook_history":         {"GetHookHistory", "[hook] [count]  show the last runs of a hook, or of all hooks", hookHistoryArgs},
+    "idle_state":           {"GetIdleState", "  show the screen idle state and the inactivity delays", noArgs},
+    "peripheral_batteries": {"GetPeripheralBatteries", "[count]  show the peripheral batteries and their last levels", countArg},
+    "power_profile":        {"GetPowerProfile", "  show the active power profile", noArgs},
+    "set_power_profile":    {"SetPowerProfile", "<name>  override the power profile, auto restores automatic switching", nameArg},
+    "suspend_history":      {"GetSuspendHistory", "[count]  show the last suspend/resume cycles", countArg},
+    "suspend_stats":        {"GetSuspendStats", "  show suspend failure statistics", noArgs},
+    "thermal":              {"GetThermalState", "[count]  show the thermal zones and the last temperature samples", countArg},
+    "tunables":             {"GetTunables", "  show the power tunables applied on battery", noArgs},
+}
+
+// printUsage prints the available CLI commands.
+func printUsage() {
+    fmt.Fprintf(os.Stderr, "Usage: %s <command> [args]\n", os.Args[0])
+    names := make([]string, 0, len(commands))
+    for name := range commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Fprintf(os.Stderr, "  %s %s\n", name, commands[name].usage)
+    }
+}
+
+// runCommand runs a CLI command against the running daemon and returns the
+// process exit code.
+func runCommand(args []string) int {
+    cmd, ok := commands[args[0]]
+    if !ok {
+        printUsage()
+        return 2
+    }
+    methodArgs, err := cmd.args(args[1:])
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 2
+    }
+
+    conn, err := dbus.ConnectSystemBus()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to connect to the system bus: %v\n", err)
+        return 1
+    }
+    defer conn.Close()
+
+    var reply string
+    obj := conn.Object(dbusutil.ServiceName, dbusutil.ServicePath)
+    if err := obj.Call(dbusutil.ServiceInterface+"."+cmd.method, 0, methodArgs...).Store(&reply); err != nil {
+        fmt.Fprintf(os.Stderr, "Failed calling %s: %v\n", cmd.method, err)
+        return 1
+    }
+
+    // The daemon replies with JSON; indent it for reading.
+    var out bytes.Buffer
+    if err := json.Indent(&out, []byte(reply), "", "  "); err != nil {
+        fmt.Println(reply)
+        return 0
+    }
+    fmt.Println(out.String())
+    return 0
+}
\ No newline at end of file
@@ -0,0 +1,237 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+)
+
+const (
+    // PathConfig is the location of the daemon configuration file.
+    PathConfig = "/etc/powerd/power_daemon.json"
+
+    // PathPowerSupply is the power_supply class directory in sysfs.
+    PathPowerSupply = "/sys/class/power_supply"
+
+    // PathSysfs is the sysfs mount point.
+    PathSysfs = "/sys"
+)
+
+// PathStateDir is the directory where the daemon keeps its persistent state.
+// Tests point it to a temporary directory.
+var PathStateDir = "/var/lib/power_daemon"
+
+// SignalHook maps a D-Bus signal to a board hook function.
+type SignalHook struct {
+    // Interface of the signal, defaults to the Power Manager interface.
+    Interface string `json:"interface"`
+    // Member is the signal name, e.g. "PowerSupplyPoll".
+    Member string `json:"member"`
+    // Proto is the full protobuf message name used to decode the signal body,
+    // e.g. "power_manager.PowerSupplyProperties". Leave empty for signals
+    // whose body consists of plain D-Bus values.
+    Proto string `json:"proto"`
+    // Match lists decoded field values that must all be equal for the hook to run.
+    Match map[string]string `json:"match"`
+    // Edge runs the hook only when the match starts to hold, e.g. once when AC
+    // is connected instead of on every PowerSupplyPoll while on AC.
+    Edge bool `json:"edge"`
+    // Hook is the board function to run, e.g. "on_ac_connected".
+    Hook string `json:"hook"`
+    // TimeoutMs bounds the hook execution time in milliseconds.
+    TimeoutMs int64 `json:"timeout_ms"`
+}
+
+// HookBudget declares how long the confs of a board hook may run.
+type HookBudget struct {
+    // DefaultMs is the budget in milliseconds of confs not listed in Confs.
+    DefaultMs int64 `json:"default_ms"`
+    // Confs maps conf file names, e.g. "10-wifi.conf", to their budget in milliseconds.
+    Confs map[string]int64 `json:"confs"`
+}
+
+// BatteryConfig holds the battery threshold hook settings, in percent.
+type BatteryConfig struct {
+    LowPercent        float64 `json:"low_percent"`
+    CriticalPercent   float64 `json:"critical_percent"`
+    FullPercent       float64 `json:"full_percent"`
+    // HysteresisPercent is how far the charge must move back before a
+    // threshold hook can fire again.
+    HysteresisPercent float64 `json:"hysteresis_percent"`
+}
+
+// ChargeControlConfig holds the default battery charge limits, in percent.
+type ChargeControlConfig struct {
+    Enabled      bool `json:"enabled"`
+    StartPercent int  `json:"start_percent"`
+    EndPercent   int  `json:"end_percent"`
+}
+
+// ProfileSettings are the platform settings applied by a power profile. Each
+// field may list several space separated values in order of preference; the
+// first one the platform offers is used. Empty fields are left alone.
+type ProfileSettings struct {
+    // PlatformProfile is written to /sys/firmware/acpi/platform_profile.
+    PlatformProfile string `json:"platform_profile"`
+    // Governor is the cpufreq scaling_governor.
+    Governor string `json:"governor"`
+    // EnergyPerformancePreference is the cpufreq energy_performance_preference.
+    EnergyPerformancePreference string `json:"energy_performance_preference"`
+    // Turbo enables or disables CPU turbo boost.
+    Turbo *bool `json:"turbo"`
+}
+
+// PowerProfileConfig selects the power profiles used automatically for each
+// power source.
+type PowerProfileConfig struct {
+    // Enabled turns on automatic switching; a user override applies regardless.
+    Enabled           bool   `json:"enabled"`
+    AcProfile         string `json:"ac_profile"`
+    BatteryProfile    string `json:"battery_profile"`
+    LowBatteryProfile string `json:"low_battery_profile"`
+    // DefaultProfile is applied when an override or a hold ends while
+    // automatic switching is off.
+    DefaultProfile    string `json:"default_profile"`
+    // Profiles overrides the settings of the built-in profiles, keyed by
+    // profile name.
+    Profiles map[string]ProfileSettings `json:"profiles"`
+}
+
+// BatterySaverConfig holds the savings applied while powerd's battery saver
+// mode is on.
+type BatterySaverConfig struct {
+    // ScreenBrightnessPercent caps the screen brightness; 0 leaves it alone.
+    ScreenBrightnessPercent float64 `json:"screen_brightness_percent"`
+    KeyboardBacklightOff    bool    `json:"keyboard_backlight_off"`
+    // Tunables maps sysfs attribute paths, which may contain glob patterns, to
+    // the values written while battery saver is on.
+    Tunables map[string]string `json:"tunables"`
+}
+
+// ThermalThresholds holds the trip temperatures of a thermal zone in degrees
+// Celsius.
+type ThermalThresholds struct {
+    WarmC float64 `json:"warm_c"`
+    HotC  float64 `json:"hot_c"`
+}
+
+// ThermalConfig holds the thermal monitoring settings.
+type ThermalConfig struct {
+    PollIntervalMs int64 `json:"poll_interval_ms"`
+    // HysteresisC is how far a zone must cool below a threshold before it
+    // leaves the level.
+    HysteresisC float64 `json:"hysteresis_c"`
+    // Default applies to zones not listed in Zones.
+    Default ThermalThresholds `json:"default"`
+    // Zones maps zone names, e.g. "thermal_zone0", or types, e.g.
+    // "x86_pkg_temp", to their thresholds.
+    Zones map[string]ThermalThresholds `json:"zones"`
+    // HotScreenBrightnessPercent caps the screen brightness while a zone is
+    // hot; 0 leaves it alone.
+    HotScreenBrightnessPercent float64 `json:"hot_screen_brightness_percent"`
+    // HotPowerProfile is forced while a zone is hot; empty leaves it alone.
+    HotPowerProfile string `json:"hot_power_profile"`
+}
+
+// TunablesConfig holds the power tunables applied on battery. Unset values
+// are left alone.
+type TunablesConfig struct {
+    // UsbAutosuspend enables autosuspend of USB devices. UsbAllow, when set,
+    // limits it to the listed devices, UsbDeny excludes devices; both hold
+    // "vid:pid" hex pairs, e.g. "046d:c52b".
+    UsbAutosuspend bool     `json:"usb_autosuspend"`
+    UsbAllow       []string `json:"usb_allow"`
+    UsbDeny        []string `json:"usb_deny"`
+    // PciRuntimePm enables runtime power management of PCI devices.
+    PciRuntimePm bool `json:"pci_runtime_pm"`
+    // SataLinkPm is the SATA link_power_management_policy, e.g. "med_power_with_dipm".
+    SataLinkPm string `json:"sata_link_pm"`
+    // AudioPowerSaveSec is the HDA codec power_save timeout in seconds.
+    AudioPowerSaveSec int `json:"audio_power_save_sec"`
+    // VmDirtyWritebackCs is vm.dirty_writeback_centisecs.
+    VmDirtyWritebackCs int `json:"vm_dirty_writeback_cs"`
+    // VmLaptopMode is vm.laptop_mode.
+    VmLaptopMode int `json:"vm_laptop_mode"`
+}
+
+// WakeupConfig enables or disables devices as wakeup sources.
+type WakeupConfig struct {
+    // Devices maps sysfs device directories, which may contain glob patterns,
+    // e.g. "/sys/bus/usb/devices/1-1", to whether they may wake the system.
+    Devices map[string]bool `json:"devices"`
+    // Acpi maps ACPI device names from /proc/acpi/wakeup, e.g. "XHC", to
+    // whether they may wake the system.
+    Acpi map[string]bool `json:"acpi"`
+}
+
+// PowerButtonConfig maps power button presses to actions: "suspend", "lock",
+// "diagnostics" or "hook:<function>". Empty actions do nothing.
+type PowerButtonConfig struct {
+    ShortPress  string `json:"short_press"`
+    LongPress   string `json:"long_press"`
+    DoublePress string `json:"double_press"`
+    // LongPressMs is the press duration from which a press is long.
+    LongPressMs int64 `json:"long_press_ms"`
+    // DoublePressMs is the longest gap between the presses of a double press.
+    DoublePressMs int64 `json:"double_press_ms"`
+    // MaxPressMs is the press duration from which a press is left to powerd
+    // alone, e.g. for a long-press shutdown.
+    MaxPressMs int64 `json:"max_press_ms"`
+}
+
+// PeripheralBatteryConfig holds the peripheral battery hook settings, in percent.
+type PeripheralBatteryConfig struct {
+    LowPercent int `json:"low_percent"`
+    // HysteresisPercent is how far the level must rise above LowPercent
+    // before the hook can fire again, if the peripheral does not report
+    // charging. Unset uses the default; 0 is allowed.
+    HysteresisPercent *int `json:"hysteresis_percent"`
+}
+
+// Config holds the daemon configuration.
+type Config struct {
+    SignalHooks []SignalHook `json:"signal_hooks"`
+    // HookBudgets maps board hook names, e.g. "pre_suspend", to their budgets.
+    HookBudgets map[string]HookBudget `json:"hook_budgets"`
+    // SuspendDelayMarginMs is added to the pre_suspend budgets when
+    // registering the suspend delay with powerd.
+    SuspendDelayMarginMs int64 `json:"suspend_delay_margin_ms"`
+    // MaxDelayLockMs caps the timeout of the suspend delay locks taken by
+    // other services over D-Bus.
+    MaxDelayLockMs int64 `json:"max_delay_lock_ms"`
+    // ShutdownBudgetMs bounds the pre_shutdown and pre_reboot hooks.
+    ShutdownBudgetMs int64 `json:"shutdown_budget_ms"`
+    Battery BatteryConfig `json:"battery"`
+    ChargeControl ChargeControlConfig `json:"charge_control"`
+    PowerProfile PowerProfileConfig `json:"power_profile"`
+    BatterySaver BatterySaverConfig `json:"battery_saver"`
+    Thermal ThermalConfig `json:"thermal"`
+    Tunables TunablesConfig `json:"tunables"`
+    Wakeup WakeupConfig `json:"wakeup"`
+    PowerButton PowerButtonConfig `json:"power_button"`
+    PeripheralBattery PeripheralBatteryConfig `json:"peripheral_battery"`
+}
+
+// Load reads the configuration from PathConfig. A missing file yields an empty
+// configuration.
+func Load() (*Config, error) {
+    return LoadFile(PathConfig)
+}
+
+// LoadFile reads the configuration from the given file.
+func LoadFile(path string) (*Config, error) {
+    cfg := &Config{}
+    buf, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        log.Printf("Config %s does not exist, using defaults", path)
+        return cfg, nil
+    } else if err != nil {
+        return cfg, err
+    }
+    if err := json.Unmarshal(buf, cfg); err != nil {
+        return &Config{}, fmt.Errorf("failed parsing %s, err:%w", path, err)
+    }
+    return cfg, nil
+}
\ No newline at end of file
@@ -10,4 +10,29 @@ const (
 
     // PowerManagerPath specifies the D-Bus object path for the Power Manager.
     PowerManagerPath = "/org/chromium/PowerManager"
+)
+
+// Constants defining the D-Bus interface, name, and path of the service
+// exported by this daemon.
+const (
+    // ServiceInterface specifies the D-Bus interface of the daemon.
+    ServiceInterface = "org.jemaos.PowerDaemon"
+
+    // ServiceName specifies the D-Bus service name of the daemon.
+    ServiceName = "org.jemaos.PowerDaemon"
+
+    // ServicePath specifies the D-Bus object path of the daemon.
+    ServicePath = "/org/jemaos/PowerDaemon"
+)
+
+// Constants defining the D-Bus interface, name, and path for the Session Manager.
+const (
+    // SessionManagerInterface specifies the D-Bus interface for the Session Manager.
+    SessionManagerInterface = "org.chromium.SessionManagerInterface"
+
+    // SessionManagerName specifies the D-Bus service name for the Session Manager.
+    SessionManagerName = "org.chromium.SessionManager"
+
+    // SessionManagerPath specifies the D-Bus object path for the Session Manager.
+    SessionManagerPath = "/org/chromium/SessionManager"
 )
\ No newline at end of file
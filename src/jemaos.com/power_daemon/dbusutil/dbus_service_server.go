@@ -0,0 +1,70 @@
+package dbusutil
+
+import (
+    "fmt"
+    "log"
+
+    "github.com/godbus/dbus/v5"
+)
+
+// ServiceServer exports the D-Bus methods of the daemon's managers under a
+// single service name and object path.
+type ServiceServer struct {
+    conn    *dbus.Conn
+    methods map[string]interface{}
+}
+
+// NewServiceServer initializes a new ServiceServer instance.
+func NewServiceServer(conn *dbus.Conn) *ServiceServer {
+    return &ServiceServer{conn, make(map[string]interface{})}
+}
+
+// RegisterMethod registers a method of the daemon interface. The method must
+// follow the godbus conventions: an optional leading dbus.Sender argument and
+// a trailing *dbus.Error return value. Methods are called on godbus
+// goroutines, concurrently with the signal handlers.
+func (service *ServiceServer) RegisterMethod(name string, method interface{}) {
+    if _, ok := service.methods[name]; ok {
+        log.Printf("Method %s is registered twice", name)
+    }
+    service.methods[name] = method
+}
+
+// Start exports the registered methods and requests the service name.
+func (service *ServiceServer) Start() error {
+    if err := service.conn.ExportMethodTable(service.methods, ServicePath, ServiceInterface); err != nil {
+        return fmt.Errorf("failed exporting %s, err:%w", ServiceInterface, err)
+    }
+    reply, err := service.conn.RequestName(ServiceName, dbus.NameFlagDoNotQueue)
+    if err != nil {
+        return fmt.Errorf("failed requesting %s, err:%w", ServiceName, err)
+    }
+    if reply != dbus.RequestNameReplyPrimaryOwner {
+        return fmt.Errorf("name %s is already taken", ServiceName)
+    }
+    log.Printf("Exported %d methods on %s", len(service.methods), ServiceName)
+    return nil
+}
+
+// Stop releases the service name and unexports the methods.
+func (service *ServiceServer) Stop() {
+    if _, err := service.conn.ReleaseName(ServiceName); err != nil {
+        log.Printf("Release name %s, got error: %v", ServiceName, err)
+    }
+    service.conn.Export(nil, ServicePath, ServiceInterface)
+}
+
+// NameHasOwner reports whether a bus name, e.g. the unique name of a client,
+// is still connected to the bus.
+func NameHasOwner(conn *dbus.Conn, name string) (bool, error) {
+    var hasOwner bool
+    err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&hasOwner)
+    return hasOwner, err
+}
+
+// GetNameOwner returns the unique name of the connection owning a bus name.
+func GetNameOwner(conn *dbus.Conn, name string) (string, error) {
+    var owner string
+    err := conn.BusObject().Call("org.freedesktop.DBus.GetNameOwner", 0, name).Store(&owner)
+    return owner, err
+}
\ No newline at end of file
@@ -5,7 +5,9 @@ import (
     "log"
     "os"
     "os/signal"
+    "strings"
     "syscall"
+    "time"
 
     "github.com/godbus/dbus/v5"
 )
@@ -16,52 +18,125 @@ type SignalHandler func(*dbus.Signal) error
 // SignalHandlers is a slice of SignalHandler functions.
 type SignalHandlers []SignalHandler
 
-// SignalMap maps signal names to their respective handlers.
+// SignalMap maps full signal names (interface.member) to their respective handlers.
 type SignalMap map[string]*SignalHandlers
 
+// TickHandler defines a function type for periodic work.
+type TickHandler func() error
+
+// ExitHandler defines a function type called with the system signal that
+// stops the signal server.
+type ExitHandler func(os.Signal) error
+
+// ticker pairs a TickHandler with its interval.
+type ticker struct {
+    interval time.Duration
+    handler  TickHandler
+}
+
 // SignalServer manages D-Bus signal registration and handling.
 type SignalServer struct {
-    ctx    context.Context
-    conn   *dbus.Conn
-    sigmap SignalMap
+    ctx     context.Context
+    conn    *dbus.Conn
+    sigmap  SignalMap
+    tickers []*ticker
+    exits   []ExitHandler
 }
 
 // NewSignalServer initializes a new SignalServer instance.
 func NewSignalServer(ctx context.Context, conn *dbus.Conn) *SignalServer {
-    return &SignalServer{ctx, conn, make(SignalMap)}
+    return &SignalServer{ctx, conn, make(SignalMap), nil, nil}
 }
 
-// RegisterSignalHandler registers a handler for a specific D-Bus signal.
+// RegisterExitHandler registers a handler called when a system signal such as
+// SIGTERM stops the signal server, before StartWorking returns.
+func (sigServer *SignalServer) RegisterExitHandler(handler ExitHandler) {
+    sigServer.exits = append(sigServer.exits, handler)
+}
+
+// handleExit invokes the exit handlers for a system signal.
+func (sigServer *SignalServer) handleExit(sig os.Signal) {
+    log.Printf("Received system signal %v, exiting", sig)
+    for _, h := range sigServer.exits {
+        if err := h(sig); err != nil {
+            log.Printf("Handler exit error: %v", err)
+        }
+    }
+}
+
+// RegisterTicker registers a handler that is called periodically. Tick handlers
+// run on the same goroutine as the signal handlers, so they need no locking
+// against them.
+func (sigServer *SignalServer) RegisterTicker(interval time.Duration, handler TickHandler) {
+    sigServer.tickers = append(sigServer.tickers, &ticker{interval, handler})
+}
+
+// startTickers starts a goroutine per ticker that forwards ticks to tickch
+// until stop is closed.
+func (sigServer *SignalServer) startTickers(tickch chan<- *ticker, stop <-chan struct{}) {
+    for _, t := range sigServer.tickers {
+        go func(t *ticker) {
+            tk := time.NewTicker(t.interval)
+            defer tk.Stop()
+            for {
+                select {
+                case <-tk.C:
+                    select {
+                    case tickch <- t:
+                    case <-stop:
+                        return
+                    }
+                case <-stop:
+                    return
+                }
+            }
+        }(t)
+    }
+}
+
+// RegisterSignalHandler registers a handler for a specific Power Manager D-Bus signal.
 func (sigServer *SignalServer) RegisterSignalHandler(sigName string, handler SignalHandler) {
-    handlers, ok := sigServer.sigmap[sigName]
+    sigServer.RegisterInterfaceSignalHandler(PowerManagerInterface, sigName, handler)
+}
+
+// RegisterInterfaceSignalHandler registers a handler for a D-Bus signal emitted
+// on any interface.
+func (sigServer *SignalServer) RegisterInterfaceSignalHandler(iface, sigName string, handler SignalHandler) {
+    name := iface + "." + sigName
+    handlers, ok := sigServer.sigmap[name]
     if !ok {
         buff := make(SignalHandlers, 0, 5)
-        sigServer.sigmap[sigName] = &buff
-        handlers = sigServer.sigmap[sigName]
+        sigServer.sigmap[name] = &buff
+        handlers = sigServer.sigmap[name]
     }
     *handlers = append(*handlers, handler)
 }
 
+// matchOptions builds the match rule for a full signal name. Power Manager
+// signals are additionally restricted to the Power Manager object path.
+func matchOptions(name string) []dbus.MatchOption {
+    idx := strings.LastIndex(name, ".")
+    iface, member := name[:idx], name[idx+1:]
+    options := []dbus.MatchOption{
+        dbus.WithMatchInterface(iface),
+        dbus.WithMatchMember(member),
+    }
+    if iface == PowerManagerInterface {
+        options = append(options, dbus.WithMatchObjectPath(PowerManagerPath))
+    }
+    return options
+}
+
 // addMatchSignal adds a match rule for a specific D-Bus signal.
-func (sigServer *SignalServer) addMatchSignal(sigName string) error {
-    log.Printf("Add signal filter path:%s, interface:%s, signal:%s",
-        PowerManagerPath, PowerManagerInterface, sigName)
-    return sigServer.conn.AddMatchSignal(
-        dbus.WithMatchObjectPath(PowerManagerPath),
-        dbus.WithMatchInterface(PowerManagerInterface),
-        dbus.WithMatchMember(sigName),
-    )
+func (sigServer *SignalServer) addMatchSignal(name string) error {
+    log.Printf("Add signal filter signal:%s", name)
+    return sigServer.conn.AddMatchSignal(matchOptions(name)...)
 }
 
 // removeMatchSignal removes a match rule for a specific D-Bus signal.
-func (sigServer *SignalServer) removeMatchSignal(sigName string) error {
-    log.Printf("Remove signal filter path:%s, interface:%s, signal:%s",
-        PowerManagerPath, PowerManagerInterface, sigName)
-    return sigServer.conn.RemoveMatchSignal(
-        dbus.WithMatchObjectPath(PowerManagerPath),
-        dbus.WithMatchInterface(PowerManagerInterface),
-        dbus.WithMatchMember(sigName),
-    )
+func (sigServer *SignalServer) removeMatchSignal(name string) error {
+    log.Printf("Remove signal filter signal:%s", name)
+    return sigServer.conn.RemoveMatchSignal(matchOptions(name)...)
 }
 
 // addAllSignals adds match rules for all registered signals.
@@ -86,9 +161,8 @@ func (sigServer *SignalServer) removeAllSignals() {
 
 // handleSignal processes an incoming D-Bus signal and invokes its handlers.
 func (sigServer *SignalServer) handleSignal(sig *dbus.Signal) {
-    member := sig.Name[len(PowerManagerInterface)+1:]
-    log.Printf("Received Signal %s, member: %s", sig.Name, member)
-    if handlers, ok := sigServer.sigmap[member]; ok {
+    log.Printf("Received Signal %s, path: %s", sig.Name, sig.Path)
+    if handlers, ok := sigServer.sigmap[sig.Name]; ok {
         for _, h := range *handlers {
             if h != nil {
                 if err := h(sig); err != nil {
@@ -109,6 +183,11 @@ func (sigServer *SignalServer) StartWorking() {
     sigServer.conn.Signal(ch)
     defer sigServer.conn.RemoveSignal(ch)
 
+    tickch := make(chan *ticker)
+    stop := make(chan struct{})
+    defer close(stop)
+    sigServer.startTickers(tickch, stop)
+
     log.Println("Start listening for signals...")
     sysch := make(chan os.Signal, 1)
     signal.Notify(sysch, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGABRT)
@@ -117,9 +196,14 @@ func (sigServer *SignalServer) StartWorking() {
         select {
         case sig := <-ch:
             sigServer.handleSignal(sig)
+        case t := <-tickch:
+            if err := t.handler(); err != nil {
+                log.Printf("Handler tick error: %v", err)
+            }
         case <-sigServer.ctx.Done():
             return
-        case <-sysch:
+        case sig := <-sysch:
+            sigServer.handleExit(sig)
             return
         }
     }
@@ -50,6 +50,22 @@ func CallProtoMethod(ctx context.Context, obj dbus.BusObject, method string, in,
     return err
 }
 
+// CallMethod calls a D-Bus method that takes and returns plain D-Bus values
+// rather than serialized protobuf messages. If out is non-nil, the first value
+// of the reply is stored into it.
+func CallMethod(ctx context.Context, obj dbus.BusObject, method string, out interface{}, args ...interface{}) error {
+    call := obj.CallWithContext(ctx, method, 0, args...)
+    if call.Err != nil {
+        return fmt.Errorf("failed calling %s, err:%w", method, call.Err)
+    }
+    if out != nil {
+        if err := call.Store(out); err != nil {
+            return fmt.Errorf("failed reading %s response, err:%w", method, err)
+        }
+    }
+    return nil
+}
+
 // DecodeSignal unmarshals the body of a D-Bus signal into the provided protobuf message.
 // The signal body must be a byte slice.
 func DecodeSignal(sig *dbus.Signal, sigResult proto.Message) error {
@@ -0,0 +1,70 @@
+package dbusutil
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+
+    "github.com/godbus/dbus/v5"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/reflect/protoreflect"
+    "google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// SignalFields holds the decoded fields of a D-Bus signal, keyed by field name.
+type SignalFields map[string]string
+
+// DecodeSignalFields decodes the body of a D-Bus signal into a flat map of
+// field names to string values. If protoName is set, the first body argument is
+// unmarshaled as that protobuf message (e.g. "power_manager.PowerSupplyProperties")
+// and nested messages are flattened with "_" separated names. Otherwise each body
+// argument is stored as "arg0", "arg1", ...
+func DecodeSignalFields(sig *dbus.Signal, protoName string) (SignalFields, error) {
+    fields := make(SignalFields)
+    if protoName == "" {
+        for i, arg := range sig.Body {
+            fields["arg"+strconv.Itoa(i)] = fmt.Sprint(arg)
+        }
+        return fields, nil
+    }
+
+    msgType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(protoName))
+    if err != nil {
+        return nil, fmt.Errorf("unknown proto message %s, err:%w", protoName, err)
+    }
+    if len(sig.Body) == 0 {
+        return nil, errors.New("signal lacked a body")
+    }
+    buf, ok := sig.Body[0].([]byte)
+    if !ok {
+        return nil, errors.New("signal body is not a byte slice")
+    }
+    msg := msgType.New().Interface()
+    if err := proto.Unmarshal(buf, msg); err != nil {
+        return nil, fmt.Errorf("failed unmarshaling signal body as %s, err:%w", protoName, err)
+    }
+    flattenMessage(fields, "", msg.ProtoReflect())
+    return fields, nil
+}
+
+// flattenMessage stores every populated field of msg into fields.
+func flattenMessage(fields SignalFields, prefix string, msg protoreflect.Message) {
+    msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+        name := prefix + string(fd.Name())
+        switch {
+        case fd.IsList() || fd.IsMap():
+            // Repeated and map fields have no meaningful flat representation.
+        case fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind:
+            flattenMessage(fields, name+"_", v.Message())
+        case fd.Kind() == protoreflect.EnumKind:
+            if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+                fields[name] = string(ev.Name())
+            } else {
+                fields[name] = strconv.Itoa(int(v.Enum()))
+            }
+        default:
+            fields[name] = v.String()
+        }
+        return true
+    })
+}
\ No newline at end of file
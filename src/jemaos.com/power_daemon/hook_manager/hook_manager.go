@@ -0,0 +1,159 @@
+package hook_manager
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // Prefix of the environment variables carrying the decoded signal fields.
+    envFieldPrefix = "POWERD_EVENT_"
+
+    // Default timeout for hook execution in milliseconds.
+    defaultExecTimeout = 2000
+
+    // D-Bus method name for querying the hook run history.
+    methdGetHookHistory = "GetHookHistory"
+)
+
+// HookRun is a recorded hook run, as returned by the GetHookHistory D-Bus
+// method.
+type HookRun struct {
+    Hook       string    `json:"hook"`
+    Conf       string    `json:"conf"`
+    Start      time.Time `json:"start"`
+    DurationMs int64     `json:"duration_ms"`
+    ExitCode   int       `json:"exit_code"`
+    Killed     bool      `json:"killed"`
+    LeftBehind []int     `json:"left_behind,omitempty"`
+    Error      string    `json:"error,omitempty"`
+    Output     string    `json:"output"`
+    Truncated  bool      `json:"truncated"`
+}
+
+// HookManager runs board hooks for the D-Bus signals listed in the
+// signal_hooks section of the daemon configuration.
+type HookManager struct {
+    ctx    context.Context
+    runner *hookutil.Runner
+    hooks  []config.SignalHook
+    // matched records whether the last signal of each hook matched, for the
+    // edge triggered hooks. It is only used from the signal goroutine.
+    matched []bool
+}
+
+// NewHookManager initializes a new HookManager instance.
+func NewHookManager(ctx context.Context, cfg *config.Config, runner *hookutil.Runner) *HookManager {
+    return &HookManager{ctx, runner, cfg.SignalHooks, make([]bool, len(cfg.SignalHooks))}
+}
+
+// matches reports whether the decoded fields satisfy every match entry.
+func matches(hook *config.SignalHook, fields dbusutil.SignalFields) bool {
+    for name, value := range hook.Match {
+        if fields[name] != value {
+            return false
+        }
+    }
+    return true
+}
+
+// hookEnv builds the hook environment from the decoded signal fields.
+func hookEnv(member string, fields dbusutil.SignalFields) []string {
+    env := []string{"POWERD_SIGNAL=" + member}
+    for name, value := range fields {
+        env = append(env, envFieldPrefix+strings.ToUpper(name)+"="+value)
+    }
+    return env
+}
+
+// handleSignal decodes the signal and runs the hook i if it matches. Edge
+// triggered hooks only run if the previous signal did not match.
+func (manager *HookManager) handleSignal(i int, signal *dbus.Signal) error {
+    hook := &manager.hooks[i]
+    fields, err := dbusutil.DecodeSignalFields(signal, hook.Proto)
+    if err != nil {
+        return err
+    }
+    matched, wasMatched := matches(hook, fields), manager.matched[i]
+    manager.matched[i] = matched
+    if !matched || (hook.Edge && wasMatched) {
+        return nil
+    }
+    log.Printf("Signal %s matched, run hook %s", signal.Name, hook.Hook)
+
+    timeout := hook.TimeoutMs
+    if timeout <= 0 {
+        timeout = defaultExecTimeout
+    }
+    ctx, cancel := context.WithTimeout(manager.ctx, time.Duration(timeout)*time.Millisecond)
+    defer cancel()
+
+    manager.runner.Run(ctx, hook.Hook, hookEnv(hook.Member, fields))
+    return nil
+}
+
+// GetHookHistory implements the GetHookHistory D-Bus method. It returns the
+// most recent count runs of hook, or of every hook if hook is empty, oldest
+// first, as JSON; 0 returns all kept runs.
+func (manager *HookManager) GetHookHistory(hook string, count uint32) (string, *dbus.Error) {
+    results := manager.runner.History(hook)
+    if count > 0 && int(count) < len(results) {
+        results = results[len(results)-int(count):]
+    }
+    runs := make([]HookRun, 0, len(results))
+    for _, result := range results {
+        run := HookRun{result.Hook, result.Conf, result.Start, result.Duration.Milliseconds(),
+            result.ExitCode, result.Killed, result.LeftBehind, "", result.Output, result.Truncated}
+        if result.Err != nil {
+            run.Error = result.Err.Error()
+        }
+        runs = append(runs, run)
+    }
+    buf, err := json.Marshal(runs)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
+
+// Register registers a signal handler for every configured hook.
+func (manager *HookManager) Register(sigServer *dbusutil.SignalServer) error {
+    for i := range manager.hooks {
+        hook := &manager.hooks[i]
+        if hook.Member == "" || hook.Hook == "" {
+            log.Printf("Skip signal hook without member or hook name: %+v", *hook)
+            continue
+        }
+        if hook.Interface == "" {
+            hook.Interface = dbusutil.PowerManagerInterface
+        }
+        i := i
+        handler := func(sig *dbus.Signal) error {
+            return manager.handleSignal(i, sig)
+        }
+        sigServer.RegisterInterfaceSignalHandler(hook.Interface, hook.Member, handler)
+        log.Printf("Map signal %s.%s to hook %s, edge: %v", hook.Interface, hook.Member, hook.Hook, hook.Edge)
+    }
+    log.Println("Hook manager registered")
+    return nil
+}
+
+// RegisterMethods registers the hook history D-Bus method with the service
+// server.
+func (manager *HookManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetHookHistory, manager.GetHookHistory)
+}
+
+// UnRegister unregisters the hook manager from the signal server.
+func (manager *HookManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering hook manager")
+    return nil
+}
\ No newline at end of file
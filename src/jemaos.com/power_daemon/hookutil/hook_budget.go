@@ -0,0 +1,71 @@
+package hookutil
+
+import (
+    "context"
+    "log"
+    "os/exec"
+    "path/filepath"
+    "time"
+)
+
+const (
+    // A warning is logged when a hook uses more than this share of its budget.
+    budgetWarnRatio = 0.8
+
+    // Timeout for checking whether a conf defines a hook.
+    defineCheckTimeout = time.Second
+
+    // defineScript exits with 0 if the conf defines the function.
+    // Arguments: $1 is the conf path, $2 the function name.
+    defineScript = `source "$1" >/dev/null 2>&1 </dev/null; declare -F "$2" >/dev/null`
+)
+
+// Budgets holds the time limits of the confs running a hook.
+type Budgets struct {
+    Default time.Duration
+    Confs   map[string]time.Duration
+}
+
+// For returns the budget of the conf with the given file name.
+func (budgets *Budgets) For(conf string) time.Duration {
+    if budget, ok := budgets.Confs[conf]; ok {
+        return budget
+    }
+    return budgets.Default
+}
+
+// Total returns the summed budget of the given confs.
+func (budgets *Budgets) Total(confs []string) (total time.Duration) {
+    for _, conf := range confs {
+        total += budgets.For(filepath.Base(conf))
+    }
+    return
+}
+
+// DefiningConfs returns the confs defining the hook function, in run order.
+func (runner *Runner) DefiningConfs(hook string) []string {
+    var confs []string
+    for _, conf := range runner.Confs() {
+        ctx, cancel := context.WithTimeout(context.Background(), defineCheckTimeout)
+        err := exec.CommandContext(ctx, pathBash, "-c", defineScript, pathBash, conf, hook).Run()
+        cancel()
+        if err == nil {
+            confs = append(confs, conf)
+        }
+    }
+    return confs
+}
+
+// RunWithBudgets is like RunWithInput, but gives every conf its own deadline
+// taken from budgets, and warns about confs getting close to their budget.
+func (runner *Runner) RunWithBudgets(ctx context.Context, hook string, env []string, input []byte, budgets *Budgets) []Result {
+    return runner.run(ctx, hook, env, input, budgets)
+}
+
+// checkBudget warns if a finished hook used most of its budget.
+func checkBudget(result *Result, budget time.Duration) {
+    if !result.Killed && result.Duration > time.Duration(float64(budget)*budgetWarnRatio) {
+        log.Printf("Hook %s in %s took %v, close to its budget of %v",
+            result.Hook, result.Conf, result.Duration, budget)
+    }
+}
\ No newline at end of file
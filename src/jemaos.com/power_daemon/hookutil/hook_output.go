@@ -0,0 +1,103 @@
+package hookutil
+
+import (
+    "bytes"
+    "log"
+    "sort"
+    "sync"
+)
+
+const (
+    // Maximum number of output bytes logged and kept for a single hook run.
+    maxOutputBytes = 16 * 1024
+
+    // Number of runs kept in memory for every hook.
+    historySize = 10
+)
+
+// outputLogger is an io.Writer that streams hook output into the daemon log
+// line by line and keeps a size-capped copy of it.
+type outputLogger struct {
+    prefix    string
+    partial   []byte
+    output    bytes.Buffer
+    truncated bool
+}
+
+// newOutputLogger creates an outputLogger prefixing every line with prefix.
+func newOutputLogger(prefix string) *outputLogger {
+    return &outputLogger{prefix: prefix}
+}
+
+// Write implements io.Writer.
+func (ol *outputLogger) Write(p []byte) (int, error) {
+    n := len(p)
+    if ol.truncated {
+        return n, nil
+    }
+    if room := maxOutputBytes - ol.output.Len(); len(p) > room {
+        p = p[:room]
+        ol.truncated = true
+    }
+    ol.output.Write(p)
+    ol.partial = append(ol.partial, p...)
+    for {
+        idx := bytes.IndexByte(ol.partial, '\n')
+        if idx < 0 {
+            break
+        }
+        log.Printf("%s %s", ol.prefix, ol.partial[:idx])
+        ol.partial = ol.partial[idx+1:]
+    }
+    if ol.truncated {
+        ol.flush()
+        log.Printf("%s output truncated at %d bytes", ol.prefix, maxOutputBytes)
+    }
+    return n, nil
+}
+
+// flush logs any incomplete last line.
+func (ol *outputLogger) flush() {
+    if len(ol.partial) > 0 {
+        log.Printf("%s %s", ol.prefix, ol.partial)
+        ol.partial = nil
+    }
+}
+
+// history keeps the most recent runs of every hook.
+type history struct {
+    mutex sync.Mutex
+    runs  map[string][]Result
+}
+
+// add records a run, dropping the oldest one once historySize is reached.
+func (h *history) add(result Result) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    if h.runs == nil {
+        h.runs = make(map[string][]Result)
+    }
+    runs := append(h.runs[result.Hook], result)
+    if len(runs) > historySize {
+        runs = runs[len(runs)-historySize:]
+    }
+    h.runs[result.Hook] = runs
+}
+
+// get returns a copy of the recorded runs of a hook, or of every hook if hook
+// is empty, oldest first.
+func (h *history) get(hook string) []Result {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    if hook != "" {
+        return append([]Result(nil), h.runs[hook]...)
+    }
+    var runs []Result
+    for _, hookRuns := range h.runs {
+        runs = append(runs, hookRuns...)
+    }
+    sort.SliceStable(runs, func(i, j int) bool {
+        return runs[i].Start.Before(runs[j].Start)
+    })
+    return runs
+}
\ No newline at end of file
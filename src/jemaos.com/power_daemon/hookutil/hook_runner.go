@@ -0,0 +1,250 @@
+package hookutil
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io/ioutil"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "syscall"
+    "time"
+)
+
+const (
+    // PathBoardDir is the directory holding the board hook configuration files.
+    PathBoardDir = "/etc/powerd/board"
+
+    // Shell used to source the board configuration files.
+    pathBash = "/bin/bash"
+
+    // Marker written by hookScript to fd 3 when the conf does not define the
+    // hook. A separate fd keeps it apart from the exit codes of the hooks.
+    markerNotDefined = "undefined"
+
+    // hookScript sources a single conf and runs the requested function in it.
+    // Arguments: $1 is the conf path, $2 the function name. The conf and the
+    // function run with fd 3 closed.
+    hookScript = `conf="$1"; func="$2"; shift 2
+source "${conf}" 3>&- || exit $?
+if ! declare -F "${func}" >/dev/null; then echo undefined >&3; exit 0; fi
+exec 3>&-
+"${func}" "$@"`
+)
+
+// Result records the outcome of running a hook function from one conf file.
+type Result struct {
+    Hook       string
+    Conf       string
+    ExitCode   int
+    Start      time.Time
+    Duration   time.Duration
+    // Output holds the combined stdout and stderr, capped at maxOutputBytes.
+    Output     string
+    Truncated  bool
+    // Killed is set if the hook exceeded its deadline and its process group
+    // was terminated.
+    Killed     bool
+    // LeftBehind lists the processes still running in the hook's process
+    // group after the hook exited. They are terminated like a hook exceeding
+    // its deadline.
+    LeftBehind []int
+    Err        error
+}
+
+// Success reports whether the hook ran to completion with a zero exit code.
+func (result *Result) Success() bool {
+    return result.Err == nil && result.ExitCode == 0
+}
+
+// Runner discovers board confs and runs each conf's hook function in its own
+// bash process, so confs cannot break or leak state into each other.
+type Runner struct {
+    board_dir string
+    history   history
+}
+
+// NewRunner initializes a new Runner for the default board directory.
+func NewRunner() *Runner {
+    return NewBoardRunner(PathBoardDir)
+}
+
+// NewBoardRunner initializes a new Runner for the confs in dir.
+func NewBoardRunner(dir string) *Runner {
+    return &Runner{board_dir: dir}
+}
+
+// History returns the most recent runs of a hook, or of every hook if hook is
+// empty, oldest first.
+func (runner *Runner) History(hook string) []Result {
+    return runner.history.get(hook)
+}
+
+// Confs returns the readable conf files in the board directory in the order
+// they are run, which is the lexical order of their file names.
+func (runner *Runner) Confs() []string {
+    confs, err := filepath.Glob(filepath.Join(runner.board_dir, "*.conf"))
+    if err != nil {
+        log.Printf("List confs in %s, got error: %v", runner.board_dir, err)
+        return nil
+    }
+    sort.Strings(confs)
+    readable := confs[:0]
+    for _, conf := range confs {
+        if f, err := os.Open(conf); err == nil {
+            f.Close()
+            readable = append(readable, conf)
+        }
+    }
+    return readable
+}
+
+// runConf runs the hook function of a single conf. It returns false if the
+// conf does not define the function.
+func (runner *Runner) runConf(ctx context.Context, hook, conf string, env []string, input []byte) (Result, bool) {
+    result := Result{Hook: hook, Conf: filepath.Base(conf)}
+    output := newOutputLogger("[" + hook + " " + result.Conf + "]")
+    cmd := exec.Command(pathBash, "-c", hookScript, pathBash, conf, hook)
+    cmd.Env = append(os.Environ(), env...)
+    if input != nil {
+        cmd.Stdin = bytes.NewReader(input)
+    }
+    cmd.Stdout = output
+    cmd.Stderr = output
+
+    if err := ctx.Err(); err != nil {
+        result.ExitCode = -1
+        result.Err = err
+        return result, true
+    }
+    marker, markerWriter, err := os.Pipe()
+    if err != nil {
+        result.ExitCode = -1
+        result.Err = err
+        return result, true
+    }
+    defer marker.Close()
+    cmd.ExtraFiles = []*os.File{markerWriter}
+
+    result.Start = time.Now()
+    err = runner.wait(ctx, cmd, &result)
+    markerWriter.Close()
+    result.Duration = time.Since(result.Start)
+    output.flush()
+    result.Output = output.output.String()
+    result.Truncated = output.truncated
+
+    if notDefined(marker) {
+        return result, false
+    }
+    var exitErr *exec.ExitError
+    if errors.As(err, &exitErr) {
+        result.ExitCode = exitErr.ExitCode()
+    } else if err != nil && err != exec.ErrWaitDelay {
+        result.ExitCode = -1
+        result.Err = err
+    }
+    if result.Killed {
+        result.Err = ctx.Err()
+    }
+    return result, true
+}
+
+// notDefined reports whether hookScript wrote the not defined marker. Only the
+// script itself holds the write end, so it is closed once the script exited.
+func notDefined(marker *os.File) bool {
+    marker.SetReadDeadline(time.Now().Add(killGracePeriod))
+    buf, _ := ioutil.ReadAll(marker)
+    return strings.TrimSpace(string(buf)) == markerNotDefined
+}
+
+// wait starts cmd in its own process group and waits for it to exit. When ctx
+// is done first, the whole group is sent SIGTERM and, after a grace period,
+// SIGKILL. Processes left in the group after cmd exited are terminated the
+// same way.
+func (runner *Runner) wait(ctx context.Context, cmd *exec.Cmd, result *Result) error {
+    if err := startInGroup(cmd); err != nil {
+        return err
+    }
+    pgid := cmd.Process.Pid
+    done := make(chan error, 1)
+    go func() {
+        done <- cmd.Wait()
+    }()
+
+    var err error
+    select {
+    case err = <-done:
+    case <-ctx.Done():
+        result.Killed = true
+        log.Printf("Hook %s in %s exceeded its deadline, terminating", result.Hook, result.Conf)
+        signalGroup(pgid, syscall.SIGTERM)
+        select {
+        case err = <-done:
+        case <-time.After(killGracePeriod):
+            log.Printf("Hook %s in %s did not terminate, killing", result.Hook, result.Conf)
+            signalGroup(pgid, syscall.SIGKILL)
+            err = <-done
+        }
+    }
+
+    if result.Killed {
+        // Children that ignored SIGTERM must not keep running into suspend.
+        signalGroup(pgid, syscall.SIGKILL)
+    } else if result.LeftBehind = groupMembers(pgid); len(result.LeftBehind) > 0 {
+        // Neither must background children of a finished hook.
+        log.Printf("Hook %s in %s left processes behind: %v, terminating", result.Hook, result.Conf, result.LeftBehind)
+        if !terminateGroup(pgid) {
+            log.Printf("Processes left behind by hook %s in %s did not terminate, killed", result.Hook, result.Conf)
+        }
+    }
+    return err
+}
+
+// Run runs the hook function of every conf that defines it, one conf at a
+// time, with env appended to the daemon environment. All runs share the
+// deadline of ctx.
+func (runner *Runner) Run(ctx context.Context, hook string, env []string) []Result {
+    return runner.RunWithInput(ctx, hook, env, nil)
+}
+
+// RunWithInput is like Run, but additionally supplies input on the standard
+// input of every conf's hook function.
+func (runner *Runner) RunWithInput(ctx context.Context, hook string, env []string, input []byte) []Result {
+    return runner.run(ctx, hook, env, input, nil)
+}
+
+// run runs the hook function of every conf. If budgets is non-nil, every conf
+// additionally gets its own deadline.
+func (runner *Runner) run(ctx context.Context, hook string, env []string, input []byte, budgets *Budgets) []Result {
+    var results []Result
+    for _, conf := range runner.Confs() {
+        confCtx, cancel := ctx, context.CancelFunc(func() {})
+        if budgets != nil {
+            confCtx, cancel = context.WithTimeout(ctx, budgets.For(filepath.Base(conf)))
+        }
+        result, defined := runner.runConf(confCtx, hook, conf, env, input)
+        cancel()
+        if !defined {
+            continue
+        }
+        if budgets != nil {
+            checkBudget(&result, budgets.For(result.Conf))
+        }
+        if result.Killed {
+            log.Printf("Hook %s in %s was killed after %v", hook, result.Conf, result.Duration)
+        } else if result.Success() {
+            log.Printf("Hook %s in %s finished in %v", hook, result.Conf, result.Duration)
+        } else {
+            log.Printf("Hook %s in %s failed in %v, exit code: %d, error: %v",
+                hook, result.Conf, result.Duration, result.ExitCode, result.Err)
+        }
+        runner.history.add(result)
+        results = append(results, result)
+    }
+    return results
+}
\ No newline at end of file
@@ -0,0 +1,168 @@
+package hookutil
+
+import (
+    "context"
+    "io/ioutil"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "jemaos.com/power_daemon/sysfsutil/sysfstest"
+)
+
+// newTestRunner returns a runner for a board directory with the given confs.
+func newTestRunner(t *testing.T, confs map[string]string) (*Runner, string) {
+    dir := t.TempDir()
+    sysfstest.WriteFiles(t, dir, confs)
+    return NewBoardRunner(dir), dir
+}
+
+// alive reports whether the process pid is running. Zombies are not.
+func alive(pid int) bool {
+    buf, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+    if err != nil {
+        return false
+    }
+    idx := strings.LastIndexByte(string(buf), ')')
+    return idx < 0 || !strings.HasPrefix(string(buf[idx+1:]), " Z")
+}
+
+// readPid reads the PID a hook wrote to path.
+func readPid(t *testing.T, path string) int {
+    t.Helper()
+    buf, err := ioutil.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return pid
+}
+
+// waitGone fails the test if the process pid is still running after a second.
+func waitGone(t *testing.T, pid int) {
+    t.Helper()
+    for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+        if !alive(pid) {
+            return
+        }
+        time.Sleep(groupPollInterval)
+    }
+    t.Errorf("Process %d still running", pid)
+}
+
+func TestRunNotDefined(t *testing.T) {
+    runner, _ := newTestRunner(t, map[string]string{
+        "a.conf": "pre_suspend() { return 254; }",
+        "b.conf": "pre_reboot() { return 1; }",
+        // Printing the marker is not the same as lacking the function.
+        "c.conf": "pre_suspend() { echo " + markerNotDefined + "; }",
+        "d.conf": "",
+    })
+    results := runner.Run(context.Background(), "pre_suspend", nil)
+    want := []struct {
+        conf     string
+        exitCode int
+    }{{"a.conf", 254}, {"c.conf", 0}}
+    if len(results) != len(want) {
+        t.Fatalf("Got %d results %+v, want %d", len(results), results, len(want))
+    }
+    for i, result := range results {
+        if result.Conf != want[i].conf || result.ExitCode != want[i].exitCode || result.Err != nil {
+            t.Errorf("Got result %s exit code %d, error: %v, want %s exit code %d",
+                result.Conf, result.ExitCode, result.Err, want[i].conf, want[i].exitCode)
+        }
+    }
+    if got := strings.TrimSpace(results[1].Output); got != markerNotDefined {
+        t.Errorf("Got output %q, want %q", got, markerNotDefined)
+    }
+    if got := len(runner.History("pre_suspend")); got != len(want) {
+        t.Errorf("Got %d runs in history, want %d", got, len(want))
+    }
+}
+
+func TestRunOutputCap(t *testing.T) {
+    tests := []struct {
+        name      string
+        bytes     int
+        truncated bool
+    }{
+        {"below cap", maxOutputBytes - 1, false},
+        {"at cap", maxOutputBytes, false},
+        {"above cap", 4 * maxOutputBytes, true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            runner, _ := newTestRunner(t, map[string]string{"a.conf": "pre_suspend() { yes | head -c " +
+                strconv.Itoa(test.bytes) + "; }"})
+            results := runner.Run(context.Background(), "pre_suspend", nil)
+            if len(results) != 1 {
+                t.Fatalf("Got %d results, want 1", len(results))
+            }
+            want := test.bytes
+            if want > maxOutputBytes {
+                want = maxOutputBytes
+            }
+            if got := len(results[0].Output); got != want || results[0].Truncated != test.truncated {
+                t.Errorf("Got %d output bytes, truncated %v, want %d, truncated %v",
+                    got, results[0].Truncated, want, test.truncated)
+            }
+            if !results[0].Success() {
+                t.Errorf("Got exit code %d, error: %v", results[0].ExitCode, results[0].Err)
+            }
+        })
+    }
+}
+
+func TestRunTimeoutKillsGroup(t *testing.T) {
+    runner, dir := newTestRunner(t, nil)
+    pidFile := filepath.Join(dir, "child.pid")
+    // The child ignores SIGTERM and only goes away with SIGKILL.
+    sysfstest.WriteFiles(t, dir, map[string]string{"a.conf": `pre_suspend() {
+    (trap '' TERM; echo $BASHPID > ` + pidFile + `; exec sleep 30) &
+    trap '' TERM
+    sleep 30
+}`})
+    ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    results := runner.Run(ctx, "pre_suspend", nil)
+    if len(results) != 1 {
+        t.Fatalf("Got %d results, want 1", len(results))
+    }
+    result := results[0]
+    if !result.Killed || result.Err == nil || result.Success() {
+        t.Errorf("Got killed %v, exit code %d, error: %v, want killed with an error",
+            result.Killed, result.ExitCode, result.Err)
+    }
+    if elapsed := time.Since(start); elapsed > 5*time.Second {
+        t.Errorf("Run took %v", elapsed)
+    }
+    waitGone(t, readPid(t, pidFile))
+}
+
+func TestRunLeftBehind(t *testing.T) {
+    runner, dir := newTestRunner(t, nil)
+    pidFile := filepath.Join(dir, "child.pid")
+    sysfstest.WriteFiles(t, dir, map[string]string{"a.conf": `pre_suspend() {
+    sleep 30 >/dev/null 2>&1 &
+    echo $! > ` + pidFile + `
+}`})
+    results := runner.Run(context.Background(), "pre_suspend", nil)
+    if len(results) != 1 {
+        t.Fatalf("Got %d results, want 1", len(results))
+    }
+    result := results[0]
+    pid := readPid(t, pidFile)
+    if result.Killed || !result.Success() {
+        t.Errorf("Got killed %v, exit code %d, error: %v, want success", result.Killed, result.ExitCode, result.Err)
+    }
+    if len(result.LeftBehind) != 1 || result.LeftBehind[0] != pid {
+        t.Errorf("Got left behind %v, want [%d]", result.LeftBehind, pid)
+    }
+    waitGone(t, pid)
+}
\ No newline at end of file
@@ -0,0 +1,88 @@
+package hookutil
+
+import (
+    "io/ioutil"
+    "log"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+)
+
+const (
+    // Time between SIGTERM and SIGKILL when a hook exceeds its deadline.
+    killGracePeriod = 100 * time.Millisecond
+
+    // Interval for checking whether a terminated process group is gone.
+    groupPollInterval = 10 * time.Millisecond
+)
+
+// signalGroup sends sig to every process in the process group pgid.
+func signalGroup(pgid int, sig syscall.Signal) {
+    if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+        log.Printf("Send %v to process group %d, got error: %v", sig, pgid, err)
+    }
+}
+
+// groupMembers returns the PIDs of the processes still in the process group pgid.
+func groupMembers(pgid int) []int {
+    stats, err := filepath.Glob("/proc/[0-9]*/stat")
+    if err != nil {
+        return nil
+    }
+    var pids []int
+    for _, stat := range stats {
+        buf, err := ioutil.ReadFile(stat)
+        if err != nil {
+            continue
+        }
+        // The command name may contain spaces, so parse the fields after it:
+        // state, ppid, pgrp, ...
+        idx := strings.LastIndexByte(string(buf), ')')
+        if idx < 0 {
+            continue
+        }
+        fields := strings.Fields(string(buf[idx+1:]))
+        if len(fields) < 3 || fields[2] != strconv.Itoa(pgid) {
+            continue
+        }
+        // Zombies are dead already, they only wait to be reaped.
+        if fields[0] == "Z" {
+            continue
+        }
+        if pid, err := strconv.Atoi(filepath.Base(filepath.Dir(stat))); err == nil {
+            pids = append(pids, pid)
+        }
+    }
+    return pids
+}
+
+// terminateGroup sends SIGTERM to the process group pgid and, if processes
+// are left after the grace period, SIGKILL. It reports whether the group
+// terminated without SIGKILL.
+func terminateGroup(pgid int) bool {
+    signalGroup(pgid, syscall.SIGTERM)
+    deadline := time.Now().Add(killGracePeriod)
+    for time.Now().Before(deadline) {
+        if len(groupMembers(pgid)) == 0 {
+            return true
+        }
+        time.Sleep(groupPollInterval)
+    }
+    if len(groupMembers(pgid)) == 0 {
+        return true
+    }
+    signalGroup(pgid, syscall.SIGKILL)
+    return false
+}
+
+// startInGroup starts cmd as the leader of a new process group.
+func startInGroup(cmd *exec.Cmd) error {
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+    // Background children left behind may keep the output pipes open; do not
+    // wait for them longer than the grace period once the hook has exited.
+    cmd.WaitDelay = killGracePeriod
+    return cmd.Start()
+}
\ No newline at end of file
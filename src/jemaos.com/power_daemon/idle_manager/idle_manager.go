@@ -0,0 +1,235 @@
+package idle_manager
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // D-Bus signal names for idle events.
+    sigScreenIdleStateChanged  = "ScreenIdleStateChanged"
+    sigIdleActionImminent      = "IdleActionImminent"
+    sigIdleActionDeferred      = "IdleActionDeferred"
+    sigInactivityDelaysChanged = "InactivityDelaysChanged"
+    sigSuspendDone             = "SuspendDone"
+
+    // D-Bus method names for querying the inactivity delays and the idle state.
+    methdGetInactivityDelays = "GetInactivityDelays"
+    methdGetIdleState        = "GetIdleState"
+
+    // Board hook functions run on idle events.
+    hookScreenDimmed       = "screen_dimmed"
+    hookScreenOff          = "screen_off"
+    hookIdleActionImminent = "idle_action_imminent"
+    hookIdleActionDeferred = "idle_action_deferred"
+
+    // Timeout for hook execution in milliseconds.
+    execTimeout = 2000
+)
+
+// InactivityDelays are powerd's inactivity delays in milliseconds.
+type InactivityDelays struct {
+    ScreenDimMs   int64 `json:"screen_dim_ms"`
+    ScreenOffMs   int64 `json:"screen_off_ms"`
+    ScreenLockMs  int64 `json:"screen_lock_ms"`
+    IdleWarningMs int64 `json:"idle_warning_ms"`
+    IdleMs        int64 `json:"idle_ms"`
+}
+
+// IdleState is the current idle state, also the reply of the GetIdleState
+// D-Bus method.
+type IdleState struct {
+    Dimmed       bool             `json:"dimmed"`
+    Off          bool             `json:"off"`
+    // IdleActionAt is when powerd will perform the idle action, set between
+    // IdleActionImminent and IdleActionDeferred or user activity.
+    IdleActionAt *time.Time       `json:"idle_action_at,omitempty"`
+    Delays       InactivityDelays `json:"delays"`
+    // Since is when the screen idle state last changed.
+    Since        time.Time        `json:"since"`
+}
+
+// IdleManager tracks powerd's idle state and runs the board idle hooks. Its
+// D-Bus method and accessors may be called from other goroutines, so the
+// state is guarded by mutex.
+type IdleManager struct {
+    ctx    context.Context
+    obj    dbus.BusObject
+    runner *hookutil.Runner
+    mutex  sync.Mutex
+    state  IdleState
+}
+
+// NewIdleManager initializes a new IdleManager instance.
+func NewIdleManager(ctx context.Context, conn *dbus.Conn, runner *hookutil.Runner) *IdleManager {
+    return &IdleManager{ctx: ctx, obj: dbusutil.GetPMObject(conn), runner: runner,
+        state: IdleState{Since: time.Now()}}
+}
+
+// State returns a copy of the current idle state.
+func (manager *IdleManager) State() IdleState {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    return manager.state
+}
+
+// runHook runs an idle hook with the given environment.
+func (manager *IdleManager) runHook(hook string, env []string) {
+    ctx, cancel := context.WithTimeout(manager.ctx, execTimeout*time.Millisecond)
+    defer cancel()
+    manager.runner.Run(ctx, hook, env)
+}
+
+// setDelays records the inactivity delays.
+func (manager *IdleManager) setDelays(delays *pmpb.PowerManagementPolicy_Delays) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    manager.state.Delays = InactivityDelays{delays.GetScreenDimMs(), delays.GetScreenOffMs(),
+        delays.GetScreenLockMs(), delays.GetIdleWarningMs(), delays.GetIdleMs()}
+    log.Printf("Inactivity delays: %+v", manager.state.Delays)
+}
+
+// HandleScreenIdleStateChanged runs the screen_dimmed and screen_off hooks
+// when the screen is dimmed or turned off for inactivity.
+func (manager *IdleManager) HandleScreenIdleStateChanged(signal *dbus.Signal) error {
+    idle := &pmpb.ScreenIdleState{}
+    if err := dbusutil.DecodeSignal(signal, idle); err != nil {
+        return err
+    }
+    manager.mutex.Lock()
+    dimmed := idle.GetDimmed() && !manager.state.Dimmed
+    off := idle.GetOff() && !manager.state.Off
+    manager.state.Dimmed, manager.state.Off = idle.GetDimmed(), idle.GetOff()
+    manager.state.Since = time.Now()
+    if !idle.GetDimmed() && !idle.GetOff() {
+        // User activity cancels a pending idle action.
+        manager.state.IdleActionAt = nil
+    }
+    manager.mutex.Unlock()
+    log.Printf("Screen idle state: dimmed: %v, off: %v", idle.GetDimmed(), idle.GetOff())
+
+    env := []string{
+        "POWERD_SCREEN_DIMMED=" + strconv.FormatBool(idle.GetDimmed()),
+        "POWERD_SCREEN_OFF=" + strconv.FormatBool(idle.GetOff()),
+    }
+    if dimmed {
+        manager.runHook(hookScreenDimmed, env)
+    }
+    if off {
+        manager.runHook(hookScreenOff, env)
+    }
+    return nil
+}
+
+// HandleIdleActionImminent runs the idle_action_imminent hook when powerd is
+// about to perform its idle action, e.g. suspend.
+func (manager *IdleManager) HandleIdleActionImminent(signal *dbus.Signal) error {
+    imminent := &pmpb.IdleActionImminent{}
+    if err := dbusutil.DecodeSignal(signal, imminent); err != nil {
+        return err
+    }
+    // powerd sends the time as a base::TimeDelta internal value, in microseconds.
+    remaining := time.Duration(imminent.GetTimeUntilIdleAction()) * time.Microsecond
+    at := time.Now().Add(remaining)
+    manager.mutex.Lock()
+    manager.state.IdleActionAt = &at
+    manager.mutex.Unlock()
+    log.Printf("Idle action imminent in %v", remaining)
+    manager.runHook(hookIdleActionImminent, []string{
+        "POWERD_IDLE_ACTION_IN_MS=" + strconv.FormatInt(remaining.Milliseconds(), 10)})
+    return nil
+}
+
+// HandleIdleActionDeferred runs the idle_action_deferred hook when user
+// activity deferred an imminent idle action.
+func (manager *IdleManager) HandleIdleActionDeferred(signal *dbus.Signal) error {
+    manager.mutex.Lock()
+    manager.state.IdleActionAt = nil
+    manager.mutex.Unlock()
+    log.Println("Idle action deferred")
+    manager.runHook(hookIdleActionDeferred, nil)
+    return nil
+}
+
+// HandleInactivityDelaysChanged records the new inactivity delays.
+func (manager *IdleManager) HandleInactivityDelaysChanged(signal *dbus.Signal) error {
+    delays := &pmpb.PowerManagementPolicy_Delays{}
+    if err := dbusutil.DecodeSignal(signal, delays); err != nil {
+        return err
+    }
+    manager.setDelays(delays)
+    return nil
+}
+
+// HandleSuspendDone clears the idle action performed by suspending.
+func (manager *IdleManager) HandleSuspendDone(signal *dbus.Signal) error {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    manager.state.IdleActionAt = nil
+    return nil
+}
+
+// GetIdleState implements the GetIdleState D-Bus method, returning the idle
+// state as JSON.
+func (manager *IdleManager) GetIdleState() (string, *dbus.Error) {
+    state := manager.State()
+    buf, err := json.Marshal(&state)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
+
+// Register queries the inactivity delays and registers the idle signal
+// handlers with the signal server.
+func (manager *IdleManager) Register(sigServer *dbusutil.SignalServer) error {
+    delays := &pmpb.PowerManagementPolicy_Delays{}
+    if err := dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(methdGetInactivityDelays), nil, delays); err != nil {
+        log.Printf("Get inactivity delays error: %v", err)
+    } else {
+        manager.setDelays(delays)
+    }
+
+    screenIdleHandler := func(sig *dbus.Signal) error {
+        return manager.HandleScreenIdleStateChanged(sig)
+    }
+    imminentHandler := func(sig *dbus.Signal) error {
+        return manager.HandleIdleActionImminent(sig)
+    }
+    deferredHandler := func(sig *dbus.Signal) error {
+        return manager.HandleIdleActionDeferred(sig)
+    }
+    delaysHandler := func(sig *dbus.Signal) error {
+        return manager.HandleInactivityDelaysChanged(sig)
+    }
+    resumeHandler := func(sig *dbus.Signal) error {
+        return manager.HandleSuspendDone(sig)
+    }
+    sigServer.RegisterSignalHandler(sigScreenIdleStateChanged, screenIdleHandler)
+    sigServer.RegisterSignalHandler(sigIdleActionImminent, imminentHandler)
+    sigServer.RegisterSignalHandler(sigIdleActionDeferred, deferredHandler)
+    sigServer.RegisterSignalHandler(sigInactivityDelaysChanged, delaysHandler)
+    sigServer.RegisterSignalHandler(sigSuspendDone, resumeHandler)
+    log.Println("Idle manager registered")
+    return nil
+}
+
+// RegisterMethods registers the idle D-Bus methods with the service server.
+func (manager *IdleManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetIdleState, manager.GetIdleState)
+}
+
+// UnRegister unregisters the idle manager from the signal server.
+func (manager *IdleManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering idle manager")
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,119 @@
+package lid_manager
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // D-Bus signal names for lid events.
+    sigLidOpened = "LidOpened"
+    sigLidClosed = "LidClosed"
+
+    // D-Bus method name for querying the switch states.
+    methdGetSwitchStates = "GetSwitchStates"
+
+    // Board hook functions run when the lid is opened or closed.
+    hookLidOpened = "lid_opened"
+    hookLidClosed = "lid_closed"
+
+    // Timeout for hook execution in milliseconds.
+    execTimeout = 2000
+)
+
+// LidManager runs the board lid hooks when the lid is opened or closed.
+type LidManager struct {
+    ctx       context.Context
+    obj       dbus.BusObject
+    runner    *hookutil.Runner
+    lid_state pmpb.SwitchStates_LidState
+}
+
+// NewLidManager initializes a new LidManager instance.
+func NewLidManager(ctx context.Context, conn *dbus.Conn, runner *hookutil.Runner) *LidManager {
+    return &LidManager{ctx, dbusutil.GetPMObject(conn), runner, pmpb.SwitchStates_NOT_PRESENT}
+}
+
+// getSwitchStates queries the Power Manager for the current lid state.
+func (manager *LidManager) getSwitchStates() (*pmpb.SwitchStates, error) {
+    rsp := &pmpb.SwitchStates{}
+    if err := dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(methdGetSwitchStates), nil, rsp); err != nil {
+        return nil, err
+    }
+    return rsp, nil
+}
+
+// runHook runs the given lid hook with a timeout.
+func (manager *LidManager) runHook(hook string) {
+    ctx, cancel := context.WithTimeout(context.Background(), execTimeout*time.Millisecond)
+    defer cancel()
+
+    manager.runner.Run(ctx, hook, nil)
+}
+
+// setLidState records the new lid state and runs the matching hook if the
+// state changed.
+func (manager *LidManager) setLidState(state pmpb.SwitchStates_LidState) {
+    if state == manager.lid_state {
+        log.Printf("Lid state is already %s", state.String())
+        return
+    }
+    manager.lid_state = state
+    log.Printf("Lid state changed to %s", state.String())
+
+    switch state {
+    case pmpb.SwitchStates_OPEN:
+        manager.runHook(hookLidOpened)
+    case pmpb.SwitchStates_CLOSED:
+        manager.runHook(hookLidClosed)
+    }
+}
+
+// handleLidOpened processes the LidOpened signal.
+func (manager *LidManager) handleLidOpened(signal *dbus.Signal) error {
+    log.Println("Received Lid Opened signal")
+    manager.setLidState(pmpb.SwitchStates_OPEN)
+    return nil
+}
+
+// handleLidClosed processes the LidClosed signal.
+func (manager *LidManager) handleLidClosed(signal *dbus.Signal) error {
+    log.Println("Received Lid Closed signal")
+    manager.setLidState(pmpb.SwitchStates_CLOSED)
+    return nil
+}
+
+// Register queries the initial lid state, runs the matching hook, and registers
+// the lid signal handlers with the signal server.
+func (manager *LidManager) Register(sigServer *dbusutil.SignalServer) error {
+    if states, err := manager.getSwitchStates(); err != nil {
+        log.Printf("Get switch states error: %v", err)
+    } else {
+        manager.setLidState(states.GetLidState())
+    }
+
+    openedHandler := func(sig *dbus.Signal) error {
+        return manager.handleLidOpened(sig)
+    }
+    closedHandler := func(sig *dbus.Signal) error {
+        return manager.handleLidClosed(sig)
+    }
+
+    sigServer.RegisterSignalHandler(sigLidOpened, openedHandler)
+    sigServer.RegisterSignalHandler(sigLidClosed, closedHandler)
+
+    log.Println("Lid manager registered")
+    return nil
+}
+
+// UnRegister unregisters the lid manager from the signal server.
+func (manager *LidManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering lid manager")
+    return nil
+}
\ No newline at end of file
@@ -8,13 +8,32 @@ import (
 
     "github.com/godbus/dbus/v5"
     "jemaos.com/power_daemon/backlight_manager"
+    "jemaos.com/power_daemon/battery_manager"
+    "jemaos.com/power_daemon/battery_saver_manager"
+    "jemaos.com/power_daemon/charge_control_manager"
+    "jemaos.com/power_daemon/config"
     "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hook_manager"
+    "jemaos.com/power_daemon/hookutil"
+    "jemaos.com/power_daemon/idle_manager"
+    "jemaos.com/power_daemon/power_profile_manager"
+    "jemaos.com/power_daemon/shutdown_manager"
+    "jemaos.com/power_daemon/lid_manager"
+    "jemaos.com/power_daemon/peripheral_battery_manager"
+    "jemaos.com/power_daemon/power_button_manager"
     "jemaos.com/power_daemon/suspend_manager"
+    "jemaos.com/power_daemon/thermal_manager"
+    "jemaos.com/power_daemon/tunables_manager"
 )
 
 // main is the entry point of the JemaOS Power Daemon.
 // It initializes the D-Bus connection, registers managers, and starts the signal server.
+// When called with arguments, it runs a CLI command against the running daemon instead.
 func main() {
+    if len(os.Args) > 1 {
+        os.Exit(runCommand(os.Args[1:]))
+    }
+
     // Set log output to standard output.
     log.SetOutput(os.Stdout)
 
@@ -30,6 +49,12 @@ func main() {
     }
     defer conn.Close()
 
+    // Load the daemon configuration.
+    cfg, err := config.Load()
+    if err != nil {
+        log.Printf("Failed to load config, using defaults: %v", err)
+    }
+
     // Create a context for managing the lifecycle of the daemon.
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
@@ -37,12 +62,19 @@ func main() {
     // Initialize the D-Bus signal server.
     sigServer := dbusutil.NewSignalServer(ctx, conn)
 
+    // Initialize the D-Bus service server exporting the daemon's methods.
+    service := dbusutil.NewServiceServer(conn)
+
+    // Initialize the board hook runner shared by the managers.
+    runner := hookutil.NewRunner()
+
     // Initialize and register the Suspend Manager.
-    suspendManager := suspend_manager.NewSuspendManager(ctx, conn)
+    suspendManager := suspend_manager.NewSuspendManager(ctx, conn, runner, cfg)
     if err := suspendManager.Register(sigServer); err != nil {
         log.Fatalf("Failed to register suspend manager: %v", err)
     }
     defer suspendManager.UnRegister(sigServer)
+    suspendManager.RegisterMethods(service)
 
     // Initialize and register the Backlight Manager.
     backlightManager := backlight_manager.NewScreenBrightnessManager(ctx, conn)
@@ -51,6 +83,124 @@ func main() {
     }
     defer backlightManager.UnRegister(sigServer)
 
+    // Initialize and register the Lid Manager.
+    lidManager := lid_manager.NewLidManager(ctx, conn, runner)
+    if err := lidManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register lid manager: %v", err)
+    }
+    defer lidManager.UnRegister(sigServer)
+
+    // Initialize and register the Idle Manager.
+    idleManager := idle_manager.NewIdleManager(ctx, conn, runner)
+    if err := idleManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register idle manager: %v", err)
+    }
+    idleManager.RegisterMethods(service)
+    defer idleManager.UnRegister(sigServer)
+
+    // Initialize and register the Battery Manager.
+    batteryManager := battery_manager.NewBatteryManager(ctx, cfg, runner, config.PathPowerSupply)
+    if err := batteryManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register battery manager: %v", err)
+    }
+    batteryManager.RegisterMethods(service)
+    defer batteryManager.UnRegister(sigServer)
+
+    // Initialize and register the Charge Control Manager.
+    chargeControlManager := charge_control_manager.NewChargeControlManager(ctx, cfg, config.PathPowerSupply)
+    if err := chargeControlManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register charge control manager: %v", err)
+    }
+    chargeControlManager.RegisterMethods(service)
+    defer chargeControlManager.UnRegister(sigServer)
+
+    // Initialize and register the Power Profile Manager. It must register after
+    // the Battery Manager, whose state it reads on PowerSupplyPoll.
+    powerProfileManager := power_profile_manager.NewPowerProfileManager(ctx, cfg, batteryManager, config.PathSysfs)
+    if err := powerProfileManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register power profile manager: %v", err)
+    }
+    powerProfileManager.RegisterMethods(service)
+    defer powerProfileManager.UnRegister(sigServer)
+
+    // Initialize and register the Battery Saver Manager.
+    batterySaverManager := battery_saver_manager.NewBatterySaverManager(ctx, conn, cfg, runner, backlightManager)
+    if err := batterySaverManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register battery saver manager: %v", err)
+    }
+    defer batterySaverManager.UnRegister(sigServer)
+
+    // Initialize and register the Thermal Manager.
+    thermalManager := thermal_manager.NewThermalManager(ctx, cfg, runner, backlightManager, powerProfileManager, config.PathSysfs)
+    if err := thermalManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register thermal manager: %v", err)
+    }
+    thermalManager.RegisterMethods(service)
+    defer thermalManager.UnRegister(sigServer)
+
+    // Initialize and register the Tunables Manager. It must register after the
+    // Battery Manager, whose state it reads on PowerSupplyPoll.
+    tunablesManager := tunables_manager.NewTunablesManager(ctx, cfg, batteryManager, tunables_manager.PathRoot)
+    if err := tunablesManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register tunables manager: %v", err)
+    }
+    tunablesManager.RegisterMethods(service)
+    defer tunablesManager.UnRegister(sigServer)
+
+    // Initialize and register the Hook Manager.
+    hookManager := hook_manager.NewHookManager(ctx, cfg, runner)
+    if err := hookManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register hook manager: %v", err)
+    }
+    hookManager.RegisterMethods(service)
+    defer hookManager.UnRegister(sigServer)
+
+    // Initialize and register the Shutdown Manager.
+    shutdownManager := shutdown_manager.NewShutdownManager(ctx, cfg, runner)
+    if err := shutdownManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register shutdown manager: %v", err)
+    }
+    defer shutdownManager.UnRegister(sigServer)
+
+    // Initialize and register the Peripheral Battery Manager.
+    peripheralBatteryManager := peripheral_battery_manager.NewPeripheralBatteryManager(ctx, cfg, runner)
+    if err := peripheralBatteryManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register peripheral battery manager: %v", err)
+    }
+    peripheralBatteryManager.RegisterMethods(service)
+    defer peripheralBatteryManager.UnRegister(sigServer)
+
+    // Initialize and register the Power Button Manager. Its diagnostics
+    // snapshot collects the state of the other managers.
+    powerButtonManager := power_button_manager.NewPowerButtonManager(ctx, conn, cfg, runner)
+    powerButtonManager.AddDiagnosticSource("battery_health", func() (string, *dbus.Error) {
+        return batteryManager.GetBatteryHealth(1)
+    })
+    powerButtonManager.AddDiagnosticSource("charge_control", chargeControlManager.GetChargeControl)
+    powerButtonManager.AddDiagnosticSource("hook_history", func() (string, *dbus.Error) {
+        // The last runs of all hooks; the output of a run may take 16KiB.
+        return hookManager.GetHookHistory("", 20)
+    })
+    powerButtonManager.AddDiagnosticSource("idle_state", idleManager.GetIdleState)
+    powerButtonManager.AddDiagnosticSource("peripheral_batteries", func() (string, *dbus.Error) {
+        return peripheralBatteryManager.GetPeripheralBatteries(1)
+    })
+    powerButtonManager.AddDiagnosticSource("power_profile", powerProfileManager.GetPowerProfile)
+    powerButtonManager.AddDiagnosticSource("thermal", func() (string, *dbus.Error) {
+        return thermalManager.GetThermalState(1)
+    })
+    powerButtonManager.AddDiagnosticSource("tunables", tunablesManager.GetTunables)
+    if err := powerButtonManager.Register(sigServer); err != nil {
+        log.Fatalf("Failed to register power button manager: %v", err)
+    }
+    defer powerButtonManager.UnRegister(sigServer)
+
+    // Export the daemon's D-Bus methods.
+    if err := service.Start(); err != nil {
+        log.Fatalf("Failed to start service server: %v", err)
+    }
+    defer service.Stop()
+
     // Start the signal server to listen for D-Bus signals.
     sigServer.StartWorking()
 }
\ No newline at end of file
@@ -0,0 +1,209 @@
+package peripheral_battery_manager
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // D-Bus signal name for peripheral battery updates, e.g. of Bluetooth
+    // mice, keyboards and styluses.
+    sigPeripheralBatteryStatus = "PeripheralBatteryStatus"
+
+    // D-Bus method name for querying the peripheral batteries.
+    methdGetPeripheralBatteries = "GetPeripheralBatteries"
+
+    // Board hook function run when a peripheral battery runs low.
+    hookPeripheralBatteryLow = "peripheral_battery_low"
+
+    // Default thresholds in percent.
+    defaultLowPercent        = 15
+    defaultHysteresisPercent = 5
+
+    // Number of level samples kept per peripheral.
+    peripheralHistorySize = 100
+
+    // Timeout for hook execution in milliseconds.
+    execTimeout = 2000
+)
+
+// LevelSample is a peripheral battery level at a point in time.
+type LevelSample struct {
+    Time  time.Time `json:"time"`
+    Level int32     `json:"level"`
+}
+
+// PeripheralBattery is the state of a peripheral battery, keyed by its path.
+type PeripheralBattery struct {
+    Path         string        `json:"path"`
+    Name         string        `json:"name"`
+    // Level is the charge in percent, -1 while unknown.
+    Level        int32         `json:"level"`
+    ChargeStatus string        `json:"charge_status"`
+    Updated      time.Time     `json:"updated"`
+    // Low is set once the low hook fired, until the battery is charged.
+    Low          bool          `json:"low"`
+    // History holds the level changes, oldest first.
+    History      []LevelSample `json:"history"`
+}
+
+// PeripheralBatteryManager tracks the peripheral batteries reported by powerd
+// and runs the board hook when one runs low. Its D-Bus method may be called
+// from other goroutines, so the state is guarded by mutex.
+type PeripheralBatteryManager struct {
+    ctx         context.Context
+    runner      *hookutil.Runner
+    low         int32
+    hysteresis  int32
+    mutex       sync.Mutex
+    peripherals map[string]*PeripheralBattery
+}
+
+// percentOr returns value, or def if value is not configured.
+func percentOr(value, def int) int32 {
+    if value <= 0 {
+        return int32(def)
+    }
+    return int32(value)
+}
+
+// NewPeripheralBatteryManager initializes a new PeripheralBatteryManager instance.
+func NewPeripheralBatteryManager(ctx context.Context, cfg *config.Config, runner *hookutil.Runner) *PeripheralBatteryManager {
+    manager := &PeripheralBatteryManager{ctx: ctx, runner: runner,
+        low: percentOr(cfg.PeripheralBattery.LowPercent, defaultLowPercent),
+        hysteresis: defaultHysteresisPercent, peripherals: make(map[string]*PeripheralBattery)}
+    if hysteresis := cfg.PeripheralBattery.HysteresisPercent; hysteresis != nil && *hysteresis >= 0 {
+        manager.hysteresis = int32(*hysteresis)
+    }
+    return manager
+}
+
+// runHook runs the peripheral_battery_low hook for a peripheral.
+func (manager *PeripheralBatteryManager) runHook(peripheral PeripheralBattery) {
+    ctx, cancel := context.WithTimeout(manager.ctx, execTimeout*time.Millisecond)
+    defer cancel()
+    manager.runner.Run(ctx, hookPeripheralBatteryLow, []string{
+        "POWERD_PERIPHERAL_PATH=" + peripheral.Path,
+        "POWERD_PERIPHERAL_NAME=" + peripheral.Name,
+        "POWERD_PERIPHERAL_LEVEL=" + strconv.Itoa(int(peripheral.Level)),
+    })
+}
+
+// update records a peripheral battery status and reports whether the low hook
+// must fire. The hook fires once per discharge cycle and re-arms when the
+// peripheral charges or its level rises past the threshold by the hysteresis.
+func (manager *PeripheralBatteryManager) update(status *pmpb.PeripheralBatteryStatus) (PeripheralBattery, bool) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    peripheral, ok := manager.peripherals[status.GetPath()]
+    if !ok {
+        peripheral = &PeripheralBattery{Path: status.GetPath(), Level: -1}
+        manager.peripherals[status.GetPath()] = peripheral
+    }
+    now := time.Now()
+    // GetLevel returns 0 for an omitted level, which must not count as empty.
+    // A status without a level keeps the last known one.
+    level := peripheral.Level
+    if status.Level != nil {
+        level = status.GetLevel()
+        if level < 0 || level > 100 {
+            level = -1
+        }
+    }
+    if status.GetName() != "" {
+        peripheral.Name = status.GetName()
+    }
+    peripheral.ChargeStatus = status.GetChargeStatus().String()
+    peripheral.Updated = now
+    if level >= 0 && level != peripheral.Level {
+        peripheral.History = append(peripheral.History, LevelSample{now, level})
+        if len(peripheral.History) > peripheralHistorySize {
+            peripheral.History = peripheral.History[len(peripheral.History)-peripheralHistorySize:]
+        }
+    }
+    peripheral.Level = level
+
+    charging := status.GetChargeStatus() == pmpb.PeripheralBatteryStatus_CHARGE_STATUS_CHARGING ||
+        status.GetChargeStatus() == pmpb.PeripheralBatteryStatus_CHARGE_STATUS_FULL
+    if peripheral.Low && (charging || level > manager.low+manager.hysteresis) {
+        peripheral.Low = false
+    }
+    if peripheral.Low || charging || level < 0 || level > manager.low {
+        return *peripheral, false
+    }
+    peripheral.Low = true
+    return *peripheral, true
+}
+
+// HandlePeripheralBatteryStatus processes the PeripheralBatteryStatus signal.
+func (manager *PeripheralBatteryManager) HandlePeripheralBatteryStatus(signal *dbus.Signal) error {
+    status := &pmpb.PeripheralBatteryStatus{}
+    if err := dbusutil.DecodeSignal(signal, status); err != nil {
+        return err
+    }
+    peripheral, low := manager.update(status)
+    if !low {
+        return nil
+    }
+    log.Printf("Peripheral battery %s (%s) low: %d%%", peripheral.Name, peripheral.Path, peripheral.Level)
+    manager.runHook(peripheral)
+    return nil
+}
+
+// GetPeripheralBatteries implements the GetPeripheralBatteries D-Bus method.
+// It returns the peripheral batteries sorted by path, each with its most
+// recent count level samples, as JSON; 0 returns all of them.
+func (manager *PeripheralBatteryManager) GetPeripheralBatteries(count uint32) (string, *dbus.Error) {
+    manager.mutex.Lock()
+    peripherals := make([]PeripheralBattery, 0, len(manager.peripherals))
+    for _, peripheral := range manager.peripherals {
+        copied := *peripheral
+        if count > 0 && int(count) < len(copied.History) {
+            copied.History = copied.History[len(copied.History)-int(count):]
+        }
+        copied.History = append([]LevelSample{}, copied.History...)
+        peripherals = append(peripherals, copied)
+    }
+    manager.mutex.Unlock()
+    sort.Slice(peripherals, func(i, j int) bool {
+        return peripherals[i].Path < peripherals[j].Path
+    })
+    buf, err := json.Marshal(peripherals)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
+
+// Register registers the peripheral battery manager with the signal server.
+func (manager *PeripheralBatteryManager) Register(sigServer *dbusutil.SignalServer) error {
+    handler := func(sig *dbus.Signal) error {
+        return manager.HandlePeripheralBatteryStatus(sig)
+    }
+    sigServer.RegisterSignalHandler(sigPeripheralBatteryStatus, handler)
+    log.Println("Peripheral battery manager registered")
+    return nil
+}
+
+// RegisterMethods registers the peripheral battery D-Bus methods with the
+// service server.
+func (manager *PeripheralBatteryManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetPeripheralBatteries, manager.GetPeripheralBatteries)
+}
+
+// UnRegister unregisters the peripheral battery manager from the signal server.
+func (manager *PeripheralBatteryManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering peripheral battery manager")
+    return nil
+}
\ No newline at end of file
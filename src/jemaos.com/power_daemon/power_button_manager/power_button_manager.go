@@ -0,0 +1,278 @@
+package power_button_manager
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // D-Bus signal name for input events.
+    sigInputEvent = "InputEvent"
+
+    // D-Bus method names for the actions.
+    methdRequestSuspend = "RequestSuspend"
+    methdLockScreen     = "LockScreen"
+
+    // Actions a press can be mapped to; "hook:<function>" runs a board hook.
+    actionSuspend     = "suspend"
+    actionLock        = "lock"
+    actionDiagnostics = "diagnostics"
+    actionHookPrefix  = "hook:"
+
+    // Kinds of presses.
+    pressShort  = "short"
+    pressLong   = "long"
+    pressDouble = "double"
+
+    // File keeping the last diagnostics snapshot, relative to the state directory.
+    fileDiagnostics = "diagnostics.json"
+
+    // Defaults of the power button config.
+    defaultLongPress   = 1000 * time.Millisecond
+    defaultDoublePress = 400 * time.Millisecond
+    defaultMaxPress    = 3000 * time.Millisecond
+
+    // Interval for checking whether a short press was not followed by a second one.
+    pressCheckInterval = 100 * time.Millisecond
+
+    // Timeout for actions in milliseconds.
+    execTimeout = 2000
+)
+
+// DiagnosticSource returns a part of the diagnostics snapshot as JSON, in the
+// form of the daemon's D-Bus query methods.
+type DiagnosticSource func() (string, *dbus.Error)
+
+// PowerButtonManager measures power button presses from powerd's input events
+// and runs the actions configured for short, long and double presses. It only
+// acts on release and never on presses longer than MaxPressMs, so powerd's own
+// long-press handling is left alone. It is only used from the signal goroutine.
+type PowerButtonManager struct {
+    ctx           context.Context
+    conn          *dbus.Conn
+    runner        *hookutil.Runner
+    cfg           config.PowerButtonConfig
+    long_press    time.Duration
+    double_press  time.Duration
+    max_press     time.Duration
+    sources       map[string]DiagnosticSource
+    // Time the button went down, zero while it is up.
+    down          time.Time
+    // Release time and duration of a short press waiting for a second press,
+    // zero if none.
+    pending       time.Time
+    pending_press time.Duration
+    // Set while the second press of a double press is down.
+    second        bool
+}
+
+// durationOr returns ms as a duration, or def if it is not configured.
+func durationOr(ms int64, def time.Duration) time.Duration {
+    if ms <= 0 {
+        return def
+    }
+    return time.Duration(ms) * time.Millisecond
+}
+
+// NewPowerButtonManager initializes a new PowerButtonManager instance.
+func NewPowerButtonManager(ctx context.Context, conn *dbus.Conn, cfg *config.Config, runner *hookutil.Runner) *PowerButtonManager {
+    manager := &PowerButtonManager{ctx: ctx, conn: conn, runner: runner, cfg: cfg.PowerButton,
+        long_press: durationOr(cfg.PowerButton.LongPressMs, defaultLongPress),
+        double_press: durationOr(cfg.PowerButton.DoublePressMs, defaultDoublePress),
+        max_press: durationOr(cfg.PowerButton.MaxPressMs, defaultMaxPress),
+        sources: make(map[string]DiagnosticSource)}
+    if manager.long_press >= manager.max_press {
+        // A long press would always be left to powerd.
+        log.Printf("Power button long press %v is not below the max press %v, using the defaults %v and %v",
+            manager.long_press, manager.max_press, defaultLongPress, defaultMaxPress)
+        manager.long_press, manager.max_press = defaultLongPress, defaultMaxPress
+    }
+    return manager
+}
+
+// AddDiagnosticSource adds a named part to the diagnostics snapshot.
+func (manager *PowerButtonManager) AddDiagnosticSource(name string, source DiagnosticSource) {
+    manager.sources[name] = source
+}
+
+// diagnostics logs a snapshot of the daemon state and keeps it in the state
+// directory.
+func (manager *PowerButtonManager) diagnostics() error {
+    names := make([]string, 0, len(manager.sources))
+    for name := range manager.sources {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    snapshot := map[string]json.RawMessage{
+        "time": json.RawMessage(strconv.Quote(time.Now().Format(time.RFC3339))),
+    }
+    for _, name := range names {
+        value, err := manager.sources[name]()
+        if err != nil {
+            log.Printf("Diagnostics %s error: %v", name, err)
+            continue
+        }
+        log.Printf("Diagnostics %s: %s", name, value)
+        snapshot[name] = json.RawMessage(value)
+    }
+    buf, err := json.Marshal(snapshot)
+    if err != nil {
+        return err
+    }
+    path := filepath.Join(config.PathStateDir, fileDiagnostics)
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+        return err
+    }
+    log.Printf("Diagnostics snapshot saved to %s", path)
+    return os.Rename(tmp, path)
+}
+
+// act runs the action configured for a press.
+func (manager *PowerButtonManager) act(press string, duration time.Duration) {
+    action := map[string]string{pressShort: manager.cfg.ShortPress, pressLong: manager.cfg.LongPress,
+        pressDouble: manager.cfg.DoublePress}[press]
+    log.Printf("Power button %s press of %v, action %q", press, duration, action)
+    ctx, cancel := context.WithTimeout(manager.ctx, execTimeout*time.Millisecond)
+    defer cancel()
+
+    var err error
+    switch {
+    case action == "":
+    case action == actionSuspend:
+        err = dbusutil.CallMethod(ctx, dbusutil.GetPMObject(manager.conn), dbusutil.GetPMMethod(methdRequestSuspend), nil)
+    case action == actionLock:
+        obj := manager.conn.Object(dbusutil.SessionManagerName, dbusutil.SessionManagerPath)
+        err = dbusutil.CallMethod(ctx, obj, dbusutil.SessionManagerInterface+"."+methdLockScreen, nil)
+    case action == actionDiagnostics:
+        err = manager.diagnostics()
+    case strings.HasPrefix(action, actionHookPrefix):
+        manager.runner.Run(ctx, strings.TrimPrefix(action, actionHookPrefix), []string{
+            "POWERD_POWER_BUTTON_PRESS=" + press,
+            "POWERD_PRESS_DURATION_MS=" + strconv.FormatInt(duration.Milliseconds(), 10),
+        })
+    default:
+        log.Printf("Unknown power button action %q", action)
+    }
+    if err != nil {
+        log.Printf("Power button action %q error: %v", action, err)
+    }
+}
+
+// handleRelease classifies a press finished at now.
+func (manager *PowerButtonManager) handleRelease(now time.Time, duration time.Duration) {
+    second := manager.second
+    manager.second = false
+    if second && duration >= manager.long_press {
+        // A long second press makes no double press, so the first one was a
+        // short press on its own.
+        manager.act(pressShort, manager.pending_press)
+    }
+    switch {
+    case duration >= manager.max_press:
+        log.Printf("Power button held for %v, left to powerd", duration)
+    case duration >= manager.long_press:
+        manager.act(pressLong, duration)
+    case second:
+        manager.act(pressDouble, duration)
+    case manager.cfg.DoublePress != "":
+        // Wait whether a second press follows.
+        manager.pending, manager.pending_press = now, duration
+    default:
+        manager.act(pressShort, duration)
+    }
+}
+
+// HandleInputEvent processes the InputEvent signal. Presses are timed with
+// the local monotonic clock when the events are received, as powerd's event
+// timestamps are optional.
+func (manager *PowerButtonManager) HandleInputEvent(signal *dbus.Signal) error {
+    event := &pmpb.InputEvent{}
+    if err := dbusutil.DecodeSignal(signal, event); err != nil {
+        return err
+    }
+    switch event.GetType() {
+    case pmpb.InputEvent_POWER_BUTTON_DOWN:
+        manager.buttonDown(time.Now())
+    case pmpb.InputEvent_POWER_BUTTON_UP:
+        manager.buttonUp(time.Now())
+    }
+    return nil
+}
+
+// buttonDown starts timing a press, which is the second one of a double press
+// if a short press is pending.
+func (manager *PowerButtonManager) buttonDown(now time.Time) {
+    manager.down = now
+    if manager.pending.IsZero() {
+        return
+    }
+    if now.Sub(manager.pending) >= manager.double_press {
+        // Too late for a double press, the ticker did not run yet.
+        manager.pending = time.Time{}
+        manager.act(pressShort, manager.pending_press)
+        return
+    }
+    manager.pending = time.Time{}
+    manager.second = true
+}
+
+// buttonUp finishes timing a press.
+func (manager *PowerButtonManager) buttonUp(now time.Time) {
+    if manager.down.IsZero() {
+        return
+    }
+    duration := now.Sub(manager.down)
+    manager.down = time.Time{}
+    manager.handleRelease(now, duration)
+}
+
+// checkPending runs the short press action once no second press followed.
+func (manager *PowerButtonManager) checkPending() error {
+    if manager.pending.IsZero() || time.Since(manager.pending) < manager.double_press {
+        return nil
+    }
+    manager.pending = time.Time{}
+    manager.act(pressShort, manager.pending_press)
+    return nil
+}
+
+// Register registers the power button manager with the signal server.
+func (manager *PowerButtonManager) Register(sigServer *dbusutil.SignalServer) error {
+    if manager.cfg.ShortPress == "" && manager.cfg.LongPress == "" && manager.cfg.DoublePress == "" {
+        log.Println("No power button actions configured")
+        return nil
+    }
+    handler := func(sig *dbus.Signal) error {
+        return manager.HandleInputEvent(sig)
+    }
+    sigServer.RegisterSignalHandler(sigInputEvent, handler)
+    if manager.cfg.DoublePress != "" {
+        sigServer.RegisterTicker(pressCheckInterval, manager.checkPending)
+    }
+    log.Println("Power button manager registered")
+    return nil
+}
+
+// UnRegister unregisters the power button manager from the signal server.
+func (manager *PowerButtonManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering power button manager")
+    return nil
+}
\ No newline at end of file
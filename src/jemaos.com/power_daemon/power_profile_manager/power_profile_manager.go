@@ -0,0 +1,412 @@
+package power_profile_manager
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/battery_manager"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+)
+
+const (
+    // D-Bus signal names for power supply updates and resume.
+    sigPowerSupplyPoll = "PowerSupplyPoll"
+    sigSuspendDone     = "SuspendDone"
+
+    // D-Bus method names of the power profile API.
+    methdGetPowerProfile = "GetPowerProfile"
+    methdSetPowerProfile = "SetPowerProfile"
+
+    // Built-in power profiles.
+    ProfilePerformance = "performance"
+    ProfileBalanced    = "balanced"
+    ProfilePowerSaver  = "power-saver"
+
+    // Sysfs files, relative to the sysfs root.
+    pathPlatformProfile        = "firmware/acpi/platform_profile"
+    pathPlatformProfileChoices = "firmware/acpi/platform_profile_choices"
+    pathCpufreqPolicies        = "devices/system/cpu/cpufreq/policy*"
+    pathNoTurbo                = "devices/system/cpu/intel_pstate/no_turbo"
+    pathBoost                  = "devices/system/cpu/cpufreq/boost"
+
+    // Attributes of a cpufreq policy.
+    attrGovernor           = "scaling_governor"
+    attrAvailableGovernors = "scaling_available_governors"
+    attrEpp                = "energy_performance_preference"
+    attrAvailableEpp       = "energy_performance_available_preferences"
+
+    // File keeping the user override, relative to the state directory.
+    filePowerProfile = "power_profile.json"
+)
+
+// boolPtr returns a pointer to b.
+func boolPtr(b bool) *bool {
+    return &b
+}
+
+// defaultProfiles are the built-in profile settings.
+var defaultProfiles = map[string]config.ProfileSettings{
+    ProfilePerformance: {PlatformProfile: "performance", Governor: "performance",
+        EnergyPerformancePreference: "performance", Turbo: boolPtr(true)},
+    // intel_pstate and amd-pstate only offer the performance and powersave
+    // governors, where powersave is the dynamic one.
+    ProfileBalanced: {PlatformProfile: "balanced", Governor: "schedutil powersave",
+        EnergyPerformancePreference: "balance_performance", Turbo: boolPtr(true)},
+    ProfilePowerSaver: {PlatformProfile: "low-power quiet", Governor: "powersave",
+        EnergyPerformancePreference: "power", Turbo: boolPtr(false)},
+}
+
+// PowerProfile is the reply of the GetPowerProfile D-Bus method.
+type PowerProfile struct {
+    Active   string            `json:"active"`
+    Auto     string            `json:"auto"`
+    Override string            `json:"override,omitempty"`
+    // Holds maps the components forcing a profile, e.g. thermal, to it.
+    Holds    map[string]string `json:"holds,omitempty"`
+    Profiles []string          `json:"profiles"`
+}
+
+// PowerProfileManager applies the power profile selected by the power source
+// and battery level, or by the user. Its D-Bus methods are called from godbus
+// goroutines, so all state is guarded by mutex.
+type PowerProfileManager struct {
+    ctx        context.Context
+    root       string
+    battery    *battery_manager.BatteryManager
+    cfg        config.PowerProfileConfig
+    profiles   map[string]config.ProfileSettings
+    state_path string
+    mutex      sync.Mutex
+    auto       string
+    override   string
+    holds      map[string]string
+    active     string
+}
+
+// NewPowerProfileManager initializes a new PowerProfileManager instance working
+// on the sysfs tree at root, normally config.PathSysfs. The battery manager
+// provides the power source and battery level.
+func NewPowerProfileManager(ctx context.Context, cfg *config.Config, battery *battery_manager.BatteryManager, root string) *PowerProfileManager {
+    manager := &PowerProfileManager{ctx: ctx, root: root, battery: battery, cfg: cfg.PowerProfile,
+        profiles: make(map[string]config.ProfileSettings), holds: make(map[string]string),
+        state_path: filepath.Join(config.PathStateDir, filePowerProfile)}
+    for name, settings := range defaultProfiles {
+        manager.profiles[name] = settings
+    }
+    for name, settings := range cfg.PowerProfile.Profiles {
+        manager.profiles[name] = mergeSettings(manager.profiles[name], settings)
+    }
+    if manager.cfg.AcProfile == "" {
+        manager.cfg.AcProfile = ProfileBalanced
+    }
+    if manager.cfg.BatteryProfile == "" {
+        manager.cfg.BatteryProfile = ProfileBalanced
+    }
+    if manager.cfg.LowBatteryProfile == "" {
+        manager.cfg.LowBatteryProfile = ProfilePowerSaver
+    }
+    if manager.cfg.DefaultProfile == "" {
+        manager.cfg.DefaultProfile = ProfileBalanced
+    }
+    if buf, err := ioutil.ReadFile(manager.state_path); err == nil {
+        var state PowerProfile
+        if err := json.Unmarshal(buf, &state); err != nil {
+            log.Printf("Parse %s error: %v", manager.state_path, err)
+        } else if _, ok := manager.profiles[state.Override]; ok {
+            manager.override = state.Override
+        }
+    }
+    return manager
+}
+
+// mergeSettings returns base with the non-empty fields of settings applied.
+func mergeSettings(base, settings config.ProfileSettings) config.ProfileSettings {
+    if settings.PlatformProfile != "" {
+        base.PlatformProfile = settings.PlatformProfile
+    }
+    if settings.Governor != "" {
+        base.Governor = settings.Governor
+    }
+    if settings.EnergyPerformancePreference != "" {
+        base.EnergyPerformancePreference = settings.EnergyPerformancePreference
+    }
+    if settings.Turbo != nil {
+        base.Turbo = settings.Turbo
+    }
+    return base
+}
+
+// readFile reads a sysfs file.
+func readFile(path string) string {
+    buf, _ := ioutil.ReadFile(path)
+    return strings.TrimSpace(string(buf))
+}
+
+// writeChoice writes the first of the space separated values that the choices
+// file, when it exists, offers to the sysfs file at path, and verifies it.
+func writeChoice(path, choicesPath, values string) error {
+    if _, err := os.Stat(path); err != nil || strings.TrimSpace(values) == "" {
+        return nil
+    }
+    value := strings.Fields(values)[0]
+    if choices := strings.Fields(readFile(choicesPath)); len(choices) > 0 {
+        value = ""
+        for _, want := range strings.Fields(values) {
+            for _, choice := range choices {
+                if value == "" && choice == want {
+                    value = want
+                }
+            }
+        }
+        if value == "" {
+            return fmt.Errorf("%s offers none of %s, choices: %v", path, values, choices)
+        }
+    }
+    if readFile(path) == value {
+        return nil
+    }
+    if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+        return err
+    }
+    if current := readFile(path); current != value {
+        return fmt.Errorf("%s is %s after writing %s", path, current, value)
+    }
+    return nil
+}
+
+// setTurbo enables or disables turbo boost through intel_pstate or the
+// generic cpufreq boost switch.
+func (manager *PowerProfileManager) setTurbo(on bool) error {
+    if path := filepath.Join(manager.root, pathNoTurbo); readFile(path) != "" {
+        value := "1"
+        if on {
+            value = "0"
+        }
+        return writeChoice(path, "", value)
+    }
+    value := "0"
+    if on {
+        value = "1"
+    }
+    return writeChoice(filepath.Join(manager.root, pathBoost), "", value)
+}
+
+// apply applies the settings of a profile. Failures of single settings are
+// logged and do not stop the others. Called with mutex held.
+func (manager *PowerProfileManager) apply(name string) {
+    settings := manager.profiles[name]
+    log.Printf("Apply power profile %s", name)
+    var errs []error
+    if settings.PlatformProfile != "" {
+        errs = append(errs, writeChoice(filepath.Join(manager.root, pathPlatformProfile),
+            filepath.Join(manager.root, pathPlatformProfileChoices), settings.PlatformProfile))
+    }
+    policies, _ := filepath.Glob(filepath.Join(manager.root, pathCpufreqPolicies))
+    for _, policy := range policies {
+        // The energy performance preference is locked under the performance
+        // governor, so the governor is set first.
+        if settings.Governor != "" {
+            errs = append(errs, writeChoice(filepath.Join(policy, attrGovernor),
+                filepath.Join(policy, attrAvailableGovernors), settings.Governor))
+        }
+        if settings.EnergyPerformancePreference != "" && readFile(filepath.Join(policy, attrGovernor)) != "performance" {
+            errs = append(errs, writeChoice(filepath.Join(policy, attrEpp),
+                filepath.Join(policy, attrAvailableEpp), settings.EnergyPerformancePreference))
+        }
+    }
+    if settings.Turbo != nil {
+        errs = append(errs, manager.setTurbo(*settings.Turbo))
+    }
+    for _, err := range errs {
+        if err != nil {
+            log.Printf("Apply power profile %s error: %v", name, err)
+        }
+    }
+    manager.active = name
+}
+
+// update applies the held, the override or the automatic profile, in this
+// order of precedence, if it changed. Once none of them selects a profile,
+// the default profile replaces the one applied before. Called with mutex held.
+func (manager *PowerProfileManager) update(force bool) {
+    target := manager.override
+    if target == "" && manager.cfg.Enabled {
+        target = manager.auto
+    }
+    owners := make([]string, 0, len(manager.holds))
+    for owner := range manager.holds {
+        owners = append(owners, owner)
+    }
+    sort.Strings(owners)
+    if len(owners) > 0 {
+        target = manager.holds[owners[0]]
+    }
+    if target == "" && manager.active != "" {
+        target = manager.cfg.DefaultProfile
+    }
+    if target == "" || (target == manager.active && !force) {
+        return
+    }
+    manager.apply(target)
+}
+
+// autoProfile returns the profile for the current power source and battery level.
+func (manager *PowerProfileManager) autoProfile() string {
+    switch {
+    case !manager.battery.OnBattery():
+        return manager.cfg.AcProfile
+    case manager.battery.IsLow():
+        return manager.cfg.LowBatteryProfile
+    default:
+        return manager.cfg.BatteryProfile
+    }
+}
+
+// HandlePowerSupplyPoll switches the profile on power source and battery
+// level changes. It runs after the battery manager's handler of the signal.
+func (manager *PowerProfileManager) HandlePowerSupplyPoll(signal *dbus.Signal) error {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    if auto := manager.autoProfile(); auto != manager.auto {
+        log.Printf("Automatic power profile changed from %s to %s", manager.auto, auto)
+        manager.auto = auto
+    }
+    manager.update(false)
+    return nil
+}
+
+// HandleSuspendDone applies the active profile again, as the firmware may
+// reset the settings on resume.
+func (manager *PowerProfileManager) HandleSuspendDone(signal *dbus.Signal) error {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    manager.update(true)
+    return nil
+}
+
+// stateJSON returns the profile state as JSON. Called with mutex held.
+func (manager *PowerProfileManager) stateJSON() (string, *dbus.Error) {
+    state := PowerProfile{Active: manager.active, Auto: manager.auto, Override: manager.override,
+        Holds: manager.holds}
+    for name := range manager.profiles {
+        state.Profiles = append(state.Profiles, name)
+    }
+    sort.Strings(state.Profiles)
+    buf, err := json.Marshal(&state)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
+
+// GetPowerProfile implements the GetPowerProfile D-Bus method.
+func (manager *PowerProfileManager) GetPowerProfile() (string, *dbus.Error) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    return manager.stateJSON()
+}
+
+// SetPowerProfile implements the SetPowerProfile D-Bus method. It overrides
+// the automatic profile until called with "auto" or an empty name. The
+// override persists across restarts.
+func (manager *PowerProfileManager) SetPowerProfile(name string) (string, *dbus.Error) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    if name == "auto" {
+        name = ""
+    }
+    if _, ok := manager.profiles[name]; name != "" && !ok {
+        return "", dbus.MakeFailedError(fmt.Errorf("unknown power profile %q", name))
+    }
+    log.Printf("Power profile override set to %q", name)
+    manager.override = name
+    if err := manager.save(); err != nil {
+        log.Printf("Save power profile error: %v", err)
+    }
+    manager.update(false)
+    return manager.stateJSON()
+}
+
+// Hold forces a profile on behalf of owner over the override and the automatic
+// choice, until Release is called for owner.
+func (manager *PowerProfileManager) Hold(owner, name string) error {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    if _, ok := manager.profiles[name]; !ok {
+        return fmt.Errorf("unknown power profile %q", name)
+    }
+    log.Printf("Power profile %s held by %s", name, owner)
+    manager.holds[owner] = name
+    manager.update(false)
+    return nil
+}
+
+// Release drops the profile held by owner.
+func (manager *PowerProfileManager) Release(owner string) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    if _, ok := manager.holds[owner]; !ok {
+        return
+    }
+    log.Printf("Power profile released by %s", owner)
+    delete(manager.holds, owner)
+    manager.update(false)
+}
+
+// save persists the user override. Called with mutex held.
+func (manager *PowerProfileManager) save() error {
+    buf, err := json.Marshal(&PowerProfile{Override: manager.override})
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(manager.state_path), 0755); err != nil {
+        return err
+    }
+    tmp := manager.state_path + ".tmp"
+    if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, manager.state_path)
+}
+
+// Register applies the initial profile and registers the power profile
+// manager with the signal server.
+func (manager *PowerProfileManager) Register(sigServer *dbusutil.SignalServer) error {
+    manager.mutex.Lock()
+    manager.auto = manager.autoProfile()
+    manager.update(false)
+    manager.mutex.Unlock()
+
+    pollHandler := func(sig *dbus.Signal) error {
+        return manager.HandlePowerSupplyPoll(sig)
+    }
+    resumeHandler := func(sig *dbus.Signal) error {
+        return manager.HandleSuspendDone(sig)
+    }
+    sigServer.RegisterSignalHandler(sigPowerSupplyPoll, pollHandler)
+    sigServer.RegisterSignalHandler(sigSuspendDone, resumeHandler)
+    log.Println("Power profile manager registered")
+    return nil
+}
+
+// RegisterMethods registers the power profile D-Bus methods with the service server.
+func (manager *PowerProfileManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetPowerProfile, manager.GetPowerProfile)
+    service.RegisterMethod(methdSetPowerProfile, manager.SetPowerProfile)
+}
+
+// UnRegister unregisters the power profile manager from the signal server.
+func (manager *PowerProfileManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering power profile manager")
+    return nil
+}
\ No newline at end of file
@@ -0,0 +1,197 @@
+package power_profile_manager
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/sysfsutil/sysfstest"
+)
+
+// newTestManager returns a manager working on a fake sysfs tree with the given
+// files, and a temporary state directory.
+func newTestManager(t *testing.T, cfg *config.Config, files map[string]string) (*PowerProfileManager, string) {
+    root := t.TempDir()
+    sysfstest.WriteFiles(t, root, files)
+    sysfstest.StateDir(t)
+    return NewPowerProfileManager(context.Background(), cfg, nil, root), root
+}
+
+func TestWriteChoice(t *testing.T) {
+    tests := []struct {
+        name    string
+        current string
+        choices string
+        values  string
+        want    string
+        wantErr bool
+    }{
+        {"no choices file", "balanced", "", "low-power quiet", "low-power", false},
+        {"first offered", "balanced", "quiet balanced performance", "low-power quiet", "quiet", false},
+        {"none offered", "balanced", "balanced performance", "low-power quiet", "balanced", true},
+        {"unchanged", "performance", "balanced performance", "performance", "performance", false},
+        {"empty values", "balanced", "balanced performance", "", "balanced", false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            root := t.TempDir()
+            files := map[string]string{pathPlatformProfile: test.current}
+            if test.choices != "" {
+                files[pathPlatformProfileChoices] = test.choices
+            }
+            sysfstest.WriteFiles(t, root, files)
+            path := filepath.Join(root, pathPlatformProfile)
+            err := writeChoice(path, filepath.Join(root, pathPlatformProfileChoices), test.values)
+            if (err != nil) != test.wantErr {
+                t.Errorf("Got error: %v, want error %v", err, test.wantErr)
+            }
+            if got := readFile(path); got != test.want {
+                t.Errorf("Got %q, want %q", got, test.want)
+            }
+        })
+    }
+}
+
+func TestWriteChoiceMissingFile(t *testing.T) {
+    root := t.TempDir()
+    path := filepath.Join(root, pathPlatformProfile)
+    if err := writeChoice(path, "", "balanced"); err != nil {
+        t.Errorf("Got error: %v", err)
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Errorf("Missing file created, stat error: %v", err)
+    }
+}
+
+func TestApply(t *testing.T) {
+    const policy = "devices/system/cpu/cpufreq/policy0/"
+    tests := []struct {
+        name    string
+        profile string
+        files   map[string]string
+        want    map[string]string
+    }{
+        {"power saver on intel_pstate", ProfilePowerSaver, map[string]string{
+            policy + attrGovernor:           "performance",
+            policy + attrAvailableGovernors: "performance powersave",
+            policy + attrEpp:                "performance",
+            policy + attrAvailableEpp:       "default performance balance_performance balance_power power",
+            pathNoTurbo:                     "0",
+        }, map[string]string{
+            policy + attrGovernor: "powersave",
+            // Written once the governor is no longer performance.
+            policy + attrEpp:      "power",
+            pathNoTurbo:           "1",
+        }},
+        {"performance locks epp", ProfilePerformance, map[string]string{
+            policy + attrGovernor:           "powersave",
+            policy + attrAvailableGovernors: "performance powersave",
+            policy + attrEpp:                "power",
+            policy + attrAvailableEpp:       "default performance balance_performance balance_power power",
+        }, map[string]string{
+            policy + attrGovernor: "performance",
+            policy + attrEpp:      "power",
+        }},
+        {"balanced on acpi-cpufreq", ProfileBalanced, map[string]string{
+            policy + attrGovernor:           "performance",
+            policy + attrAvailableGovernors: "ondemand schedutil performance",
+            pathBoost:                       "0",
+            pathPlatformProfile:             "low-power",
+            pathPlatformProfileChoices:      "low-power balanced performance",
+        }, map[string]string{
+            policy + attrGovernor: "schedutil",
+            pathBoost:             "1",
+            pathPlatformProfile:   "balanced",
+        }},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            manager, root := newTestManager(t, &config.Config{}, test.files)
+            manager.apply(test.profile)
+            if manager.active != test.profile {
+                t.Errorf("Got active profile %q, want %q", manager.active, test.profile)
+            }
+            for path, want := range test.want {
+                if got := readFile(filepath.Join(root, path)); got != want {
+                    t.Errorf("Got %s %q, want %q", path, got, want)
+                }
+            }
+        })
+    }
+}
+
+func TestUpdatePrecedence(t *testing.T) {
+    tests := []struct {
+        name     string
+        enabled  bool
+        auto     string
+        override string
+        holds    map[string]string
+        want     string
+    }{
+        {"auto", true, ProfilePowerSaver, "", nil, ProfilePowerSaver},
+        {"auto disabled", false, ProfilePowerSaver, "", nil, ""},
+        {"override", true, ProfilePowerSaver, ProfilePerformance, nil, ProfilePerformance},
+        {"override while auto disabled", false, ProfilePowerSaver, ProfilePerformance, nil, ProfilePerformance},
+        {"hold", true, ProfileBalanced, ProfilePerformance, map[string]string{"thermal": ProfilePowerSaver},
+            ProfilePowerSaver},
+        {"first holder", true, ProfileBalanced, "", map[string]string{"thermal": ProfilePowerSaver, "battery_saver": ProfileBalanced},
+            ProfileBalanced},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            manager, _ := newTestManager(t, &config.Config{}, nil)
+            manager.cfg.Enabled = test.enabled
+            manager.auto, manager.override = test.auto, test.override
+            for owner, name := range test.holds {
+                manager.holds[owner] = name
+            }
+            manager.update(false)
+            if manager.active != test.want {
+                t.Errorf("Got active profile %q, want %q", manager.active, test.want)
+            }
+        })
+    }
+}
+
+func TestRestoreWithAutoDisabled(t *testing.T) {
+    tests := []struct {
+        name    string
+        profile string
+        restore func(*PowerProfileManager)
+        want    string
+    }{
+        {"release", "", func(manager *PowerProfileManager) {
+            manager.Hold("thermal", ProfilePowerSaver)
+            manager.Release("thermal")
+        }, ProfileBalanced},
+        {"release to configured default", ProfilePerformance, func(manager *PowerProfileManager) {
+            manager.Hold("thermal", ProfilePowerSaver)
+            manager.Release("thermal")
+        }, ProfilePerformance},
+        {"override reset", "", func(manager *PowerProfileManager) {
+            manager.SetPowerProfile(ProfilePowerSaver)
+            manager.SetPowerProfile("auto")
+        }, ProfileBalanced},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            cfg := &config.Config{}
+            cfg.PowerProfile.DefaultProfile = test.profile
+            manager, root := newTestManager(t, cfg, map[string]string{
+                pathPlatformProfile:        "balanced",
+                pathPlatformProfileChoices: "low-power balanced performance",
+            })
+            test.restore(manager)
+            if manager.active != test.want {
+                t.Errorf("Got active profile %q, want %q", manager.active, test.want)
+            }
+            want := defaultProfiles[test.want].PlatformProfile
+            if got := readFile(filepath.Join(root, pathPlatformProfile)); got != want {
+                t.Errorf("Got platform profile %q, want %q", got, want)
+            }
+        })
+    }
+}
\ No newline at end of file
@@ -0,0 +1,139 @@
+package shutdown_manager
+
+import (
+    "context"
+    "log"
+    "os"
+    "os/exec"
+    "strings"
+    "syscall"
+    "time"
+
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // Board hook functions run before power-off and before reboot.
+    hookPreShutdown = "pre_shutdown"
+    hookPreReboot   = "pre_reboot"
+
+    // Upstart tools for querying job states and the runlevel.
+    pathInitctl  = "/sbin/initctl"
+    pathRunlevel = "/sbin/runlevel"
+
+    // Upstart jobs started by the runlevel event powerd emits to shut down or reboot.
+    jobHalt   = "halt"
+    jobReboot = "reboot"
+
+    // Runlevels of that event.
+    runlevelShutdown = "0"
+    runlevelReboot   = "6"
+
+    // Default time budget of the shutdown hooks in milliseconds. It must stay
+    // below the upstart kill timeout of the daemon job.
+    defaultShutdownBudget = 3000
+
+    // Timeout for querying upstart.
+    upstartTimeout = 500 * time.Millisecond
+)
+
+// ShutdownManager runs the board shutdown and reboot hooks. powerd shuts the
+// system down or reboots it by emitting the upstart runlevel event, which
+// starts the halt or reboot job and stops boot-services, and therefore sends
+// SIGTERM to the daemon. powerd does not broadcast the shutdown, so it is told
+// apart from a plain stop of the daemon by the upstart state.
+type ShutdownManager struct {
+    ctx    context.Context
+    runner *hookutil.Runner
+    budget time.Duration
+}
+
+// NewShutdownManager initializes a new ShutdownManager instance.
+func NewShutdownManager(ctx context.Context, cfg *config.Config, runner *hookutil.Runner) *ShutdownManager {
+    budget := cfg.ShutdownBudgetMs
+    if budget <= 0 {
+        budget = defaultShutdownBudget
+    }
+    return &ShutdownManager{ctx: ctx, runner: runner, budget: time.Duration(budget) * time.Millisecond}
+}
+
+// runUpstart runs an upstart tool and returns its output.
+func runUpstart(name string, args ...string) (string, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), upstartTimeout)
+    defer cancel()
+    out, err := exec.CommandContext(ctx, name, args...).Output()
+    return string(out), err
+}
+
+// jobStarting reports whether the goal of an upstart job is start, e.g.
+// "reboot start/running, process 1234".
+func jobStarting(job string) bool {
+    out, err := runUpstart(pathInitctl, "status", job)
+    return err == nil && strings.HasPrefix(out, job+" start/")
+}
+
+// getRunlevel returns the runlevel the system is moving to, or an empty
+// string if it is unknown.
+func getRunlevel() string {
+    if jobStarting(jobHalt) {
+        return runlevelShutdown
+    }
+    if jobStarting(jobReboot) {
+        return runlevelReboot
+    }
+    out, err := runUpstart(pathRunlevel)
+    if err != nil {
+        log.Printf("Get runlevel error: %v", err)
+        return ""
+    }
+    // The output is "<previous> <current>", e.g. "2 6".
+    fields := strings.Fields(out)
+    if len(fields) != 2 {
+        return ""
+    }
+    return fields[1]
+}
+
+// handleExit runs the shutdown or reboot hooks when the daemon is stopped
+// because the system is going down, as told by the runlevel. A plain stop of
+// the daemon runs nothing.
+func (manager *ShutdownManager) handleExit(sig os.Signal) error {
+    if sig != syscall.SIGTERM {
+        return nil
+    }
+
+    var hook string
+    switch runlevel := getRunlevel(); runlevel {
+    case runlevelShutdown:
+        hook = hookPreShutdown
+    case runlevelReboot:
+        hook = hookPreReboot
+    default:
+        log.Printf("Stopped in runlevel %q, not shutting down", runlevel)
+        return nil
+    }
+
+    log.Printf("System is going down, run %s hooks within %v", hook, manager.budget)
+    ctx, cancel := context.WithTimeout(context.Background(), manager.budget)
+    defer cancel()
+    manager.runner.Run(ctx, hook, nil)
+    return nil
+}
+
+// Register registers the shutdown manager with the signal server.
+func (manager *ShutdownManager) Register(sigServer *dbusutil.SignalServer) error {
+    exitHandler := func(sig os.Signal) error {
+        return manager.handleExit(sig)
+    }
+    sigServer.RegisterExitHandler(exitHandler)
+    log.Println("Shutdown manager registered")
+    return nil
+}
+
+// UnRegister unregisters the shutdown manager from the signal server.
+func (manager *ShutdownManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    log.Println("Unregistering shutdown manager")
+    return nil
+}
\ No newline at end of file
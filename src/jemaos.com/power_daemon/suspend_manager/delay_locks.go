@@ -0,0 +1,227 @@
+package suspend_manager
+
+import (
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/dbusutil"
+)
+
+const (
+    // D-Bus method names of the delay lock API.
+    methdAcquireSuspendDelay = "AcquireSuspendDelay"
+    methdReleaseSuspendDelay = "ReleaseSuspendDelay"
+
+    // D-Bus signal emitted on the daemon interface when a suspend is pending,
+    // so lock holders need not follow powerd's signals.
+    sigDaemonSuspendImminent = "SuspendImminent"
+
+    // Bus signal telling that a bus name, e.g. a lock holder, changed owner.
+    busInterface        = "org.freedesktop.DBus"
+    sigNameOwnerChanged = "NameOwnerChanged"
+
+    // Default upper limit of a delay lock timeout in milliseconds.
+    defaultMaxDelayLock = 2000
+)
+
+var errUnknownLock = errors.New("unknown delay lock")
+
+// delayLock is a named lock taken by another service to delay suspend until
+// it has finished its work.
+type delayLock struct {
+    id      uint32
+    name    string
+    owner   string
+    timeout time.Duration
+}
+
+// delayLocks keeps the delay locks taken over D-Bus. Its methods are called
+// from godbus goroutines, the signal loop and the holder watch, so all state
+// is guarded by mutex. Holders leaving the bus are learned from
+// NameOwnerChanged, matched per holder.
+type delayLocks struct {
+    conn        *dbus.Conn
+    mutex       sync.Mutex
+    next_id     uint32
+    max_timeout time.Duration
+    locks       map[uint32]*delayLock
+    signals     chan *dbus.Signal
+}
+
+// newDelayLocks creates an empty lock table.
+func newDelayLocks(conn *dbus.Conn) *delayLocks {
+    return &delayLocks{conn: conn, next_id: 1, max_timeout: defaultMaxDelayLock * time.Millisecond,
+        locks: make(map[uint32]*delayLock)}
+}
+
+// setMaxTimeout sets the upper limit of the lock timeouts.
+func (dl *delayLocks) setMaxTimeout(maxTimeout time.Duration) {
+    dl.mutex.Lock()
+    defer dl.mutex.Unlock()
+    dl.max_timeout = maxTimeout
+}
+
+// holderMatch returns the match rule for the NameOwnerChanged signals of a
+// lock holder's unique name.
+func holderMatch(owner string) []dbus.MatchOption {
+    return []dbus.MatchOption{
+        dbus.WithMatchSender(busInterface),
+        dbus.WithMatchInterface(busInterface),
+        dbus.WithMatchMember(sigNameOwnerChanged),
+        dbus.WithMatchArg(0, owner),
+    }
+}
+
+// holds reports whether owner holds any lock. Called with mutex held.
+func (dl *delayLocks) holds(owner string) bool {
+    for _, lock := range dl.locks {
+        if lock.owner == owner {
+            return true
+        }
+    }
+    return false
+}
+
+// unwatch removes the NameOwnerChanged match of owner once it holds no lock.
+func (dl *delayLocks) unwatch(owner string) {
+    dl.mutex.Lock()
+    holds := dl.holds(owner)
+    dl.mutex.Unlock()
+    if holds {
+        return
+    }
+    if err := dl.conn.RemoveMatchSignal(holderMatch(owner)...); err != nil {
+        log.Printf("Remove match of delay lock holder %s error: %v", owner, err)
+    }
+}
+
+// acquire implements the AcquireSuspendDelay D-Bus method. It returns the ID
+// to pass to ReleaseSuspendDelay.
+func (dl *delayLocks) acquire(sender dbus.Sender, name string, timeoutMs uint32) (uint32, *dbus.Error) {
+    dl.mutex.Lock()
+    timeout := time.Duration(timeoutMs) * time.Millisecond
+    if timeout <= 0 || timeout > dl.max_timeout {
+        timeout = dl.max_timeout
+    }
+    lock := &delayLock{dl.next_id, name, string(sender), timeout}
+    dl.next_id++
+    watched := dl.holds(lock.owner)
+    dl.locks[lock.id] = lock
+    dl.mutex.Unlock()
+    log.Printf("Delay lock %d (%s) acquired by %s, timeout %v", lock.id, name, sender, timeout)
+
+    if !watched {
+        if err := dl.conn.AddMatchSignal(holderMatch(lock.owner)...); err != nil {
+            log.Printf("Watch delay lock holder %s error: %v", lock.owner, err)
+        }
+        // The holder may have left before the match was in place.
+        if ok, err := dbusutil.NameHasOwner(dl.conn, lock.owner); err == nil && !ok {
+            dl.vanished(lock.owner)
+        }
+    }
+    return lock.id, nil
+}
+
+// release implements the ReleaseSuspendDelay D-Bus method.
+func (dl *delayLocks) release(sender dbus.Sender, id uint32) *dbus.Error {
+    dl.mutex.Lock()
+    lock, ok := dl.locks[id]
+    if !ok || lock.owner != string(sender) {
+        dl.mutex.Unlock()
+        return dbus.MakeFailedError(errUnknownLock)
+    }
+    delete(dl.locks, id)
+    dl.mutex.Unlock()
+    log.Printf("Delay lock %d (%s) released", id, lock.name)
+    dl.unwatch(lock.owner)
+    return nil
+}
+
+// vanished drops the locks of a holder that left the bus.
+func (dl *delayLocks) vanished(owner string) {
+    dl.mutex.Lock()
+    for id, lock := range dl.locks {
+        if lock.owner == owner {
+            log.Printf("Delay lock %d (%s) holder %s left the bus", id, lock.name, owner)
+            delete(dl.locks, id)
+        }
+    }
+    dl.mutex.Unlock()
+    dl.unwatch(owner)
+}
+
+// watch drops the locks of holders leaving the bus until the signal channel
+// is closed. It runs on its own goroutine, so holders are dropped while the
+// signal loop runs the hooks.
+func (dl *delayLocks) watch(signals <-chan *dbus.Signal) {
+    for sig := range signals {
+        if sig.Name != busInterface+"."+sigNameOwnerChanged || len(sig.Body) != 3 {
+            continue
+        }
+        name, _ := sig.Body[0].(string)
+        newOwner, _ := sig.Body[2].(string)
+        if newOwner != "" {
+            continue
+        }
+        dl.mutex.Lock()
+        holds := dl.holds(name)
+        dl.mutex.Unlock()
+        if holds {
+            dl.vanished(name)
+        }
+    }
+}
+
+// start starts watching the lock holders.
+func (dl *delayLocks) start() {
+    dl.signals = make(chan *dbus.Signal, 10)
+    dl.conn.Signal(dl.signals)
+    go dl.watch(dl.signals)
+}
+
+// stop stops watching the lock holders.
+func (dl *delayLocks) stop() {
+    if dl.signals == nil {
+        return
+    }
+    dl.conn.RemoveSignal(dl.signals)
+    close(dl.signals)
+    dl.signals = nil
+}
+
+// notify emits the SuspendImminent signal of the daemon interface, telling
+// the lock holders that suspend waits for their locks for at most the maximum
+// lock timeout.
+func (dl *delayLocks) notify(suspendId int32) {
+    maxTimeoutMs := uint32(dl.maxTimeout().Milliseconds())
+    err := dl.conn.Emit(dbusutil.ServicePath, dbusutil.ServiceInterface+"."+sigDaemonSuspendImminent,
+        suspendId, maxTimeoutMs)
+    if err != nil {
+        log.Printf("Emit %s error: %v", sigDaemonSuspendImminent, err)
+    }
+}
+
+// pending drops expired locks and reports whether any lock is still held.
+// Locks expire once their timeout has passed since start.
+func (dl *delayLocks) pending(start time.Time) bool {
+    dl.mutex.Lock()
+    defer dl.mutex.Unlock()
+    elapsed := time.Since(start)
+    for id, lock := range dl.locks {
+        if elapsed >= lock.timeout {
+            log.Printf("Delay lock %d (%s) timed out after %v", id, lock.name, lock.timeout)
+            delete(dl.locks, id)
+        }
+    }
+    return len(dl.locks) > 0
+}
+
+// maxTimeout returns the upper limit of the lock timeouts.
+func (dl *delayLocks) maxTimeout() time.Duration {
+    dl.mutex.Lock()
+    defer dl.mutex.Unlock()
+    return dl.max_timeout
+}
\ No newline at end of file
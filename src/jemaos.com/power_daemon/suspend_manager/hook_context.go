@@ -0,0 +1,134 @@
+package suspend_manager
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "strconv"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/dbusutil"
+)
+
+const (
+    // D-Bus signal name for power supply updates.
+    sigPowerSupplyPoll = "PowerSupplyPoll"
+
+    // D-Bus method name for querying the power supply state.
+    methdGetPowerSupplyProperties = "GetPowerSupplyProperties"
+
+    // Timeout for querying the power supply state in milliseconds.
+    powerSupplyTimeout = 50
+)
+
+// hookContext describes the suspend event passed to the board hooks, both as
+// POWERD_* environment variables and as a JSON document on stdin.
+type hookContext struct {
+    Event             string  `json:"event"`
+    SuspendId         int32   `json:"suspend_id"`
+    SuspendReason     string  `json:"suspend_reason,omitempty"`
+    WakeupType        string  `json:"wakeup_type,omitempty"`
+    WakeSource        string  `json:"wake_source,omitempty"`
+    WakeDevice        string  `json:"wake_device,omitempty"`
+    WakeIrq           int     `json:"wake_irq,omitempty"`
+    SuspendDurationUs int64   `json:"suspend_duration_us,omitempty"`
+    ResumeType        string  `json:"resume_type,omitempty"`
+    DarkResumeCount   int     `json:"dark_resume_count"`
+    FailureReason     string  `json:"failure_reason,omitempty"`
+    FailedDevice      string  `json:"failed_device,omitempty"`
+    FailedStep        string  `json:"failed_step,omitempty"`
+    PowerSource       string  `json:"power_source,omitempty"`
+    BatteryPercent    float64 `json:"battery_percent"`
+}
+
+// queryPowerSupply fetches the power supply state from powerd once, before
+// the first PowerSupplyPoll signal arrives.
+func (manager *SuspendManager) queryPowerSupply() {
+    ctx, cancel := context.WithTimeout(manager.ctx, powerSupplyTimeout*time.Millisecond)
+    defer cancel()
+    props := &pmpb.PowerSupplyProperties{}
+    if err := dbusutil.CallProtoMethod(ctx, manager.obj, dbusutil.GetPMMethod(methdGetPowerSupplyProperties), nil, props); err != nil {
+        log.Printf("Get power supply properties error: %v", err)
+        return
+    }
+    manager.power_supply = props
+}
+
+// handlePowerSupplyPoll caches the power supply state for the hook context.
+func (manager *SuspendManager) handlePowerSupplyPoll(signal *dbus.Signal) error {
+    props := &pmpb.PowerSupplyProperties{}
+    if err := dbusutil.DecodeSignal(signal, props); err != nil {
+        return err
+    }
+    manager.power_supply = props
+    return nil
+}
+
+// addPowerSupply fills in the cached power source and battery percentage, so
+// no D-Bus call eats into the hook deadlines. Without a cached state the
+// fields stay empty, the hooks still run.
+func (hc *hookContext) addPowerSupply(manager *SuspendManager) {
+    if manager.power_supply == nil {
+        return
+    }
+    hc.PowerSource = manager.power_supply.GetExternalPower().String()
+    hc.BatteryPercent = manager.power_supply.GetBatteryPercent()
+}
+
+// env returns the context as POWERD_* environment variables.
+func (hc *hookContext) env() []string {
+    env := []string{
+        "POWERD_EVENT=" + hc.Event,
+        "POWERD_SUSPEND_ID=" + strconv.Itoa(int(hc.SuspendId)),
+        "POWERD_POWER_SOURCE=" + hc.PowerSource,
+        "POWERD_BATTERY_PERCENT=" + strconv.FormatFloat(hc.BatteryPercent, 'f', 1, 64),
+    }
+    if hc.SuspendReason != "" {
+        env = append(env, "POWERD_SUSPEND_REASON="+hc.SuspendReason)
+    }
+    if hc.ResumeType != "" {
+        env = append(env, "POWERD_RESUME_TYPE="+hc.ResumeType,
+            "POWERD_DARK_RESUME_COUNT="+strconv.Itoa(hc.DarkResumeCount))
+    }
+    if hc.WakeupType != "" {
+        env = append(env, "POWERD_WAKEUP_TYPE="+hc.WakeupType,
+            "POWERD_SUSPEND_DURATION_US="+strconv.FormatInt(hc.SuspendDurationUs, 10))
+    }
+    // The wake attribution is only exported when known, like in the JSON.
+    if hc.WakeSource != "" {
+        env = append(env, "POWERD_WAKE_SOURCE="+hc.WakeSource)
+    }
+    if hc.WakeDevice != "" {
+        env = append(env, "POWERD_WAKE_DEVICE="+hc.WakeDevice)
+    }
+    if hc.WakeIrq != 0 {
+        env = append(env, "POWERD_WAKE_IRQ="+strconv.Itoa(hc.WakeIrq))
+    }
+    if hc.FailureReason != "" {
+        env = append(env, "POWERD_FAILURE_REASON="+hc.FailureReason,
+            "POWERD_FAILED_DEVICE="+hc.FailedDevice,
+            "POWERD_FAILED_STEP="+hc.FailedStep)
+    }
+    return env
+}
+
+// input returns the context as a JSON document.
+func (hc *hookContext) input() []byte {
+    buf, err := json.Marshal(hc)
+    if err != nil {
+        log.Printf("Marshal hook context error: %v", err)
+        return nil
+    }
+    return append(buf, '\n')
+}
+
+// runHook runs the given hook with the context and records the results in
+// the suspend history.
+func (manager *SuspendManager) runHook(ctx context.Context, hook string, hc *hookContext) {
+    hc.Event = hook
+    hc.addPowerSupply(manager)
+    results := manager.runner.RunWithBudgets(ctx, hook, hc.env(), hc.input(), manager.hookBudgets(hook))
+    manager.history.addHooks(results)
+}
\ No newline at end of file
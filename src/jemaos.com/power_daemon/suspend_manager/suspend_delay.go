@@ -0,0 +1,180 @@
+package suspend_manager
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // Default margin added to the hook budgets, in milliseconds.
+    defaultSuspendDelayMargin = 500
+
+    // Interval for checking the config and board confs for changes.
+    configCheckInterval = 30 * time.Second
+)
+
+// suspendDelay tracks a suspend delay registered with powerd, which waits for
+// the readiness of the delay before suspending.
+type suspendDelay struct {
+    // hook is the board hook run while powerd waits for the delay.
+    hook            string
+    methdRegister   string
+    methdUnregister string
+    methdReadiness  string
+    id              int32
+    timeout         time.Duration
+}
+
+// newSuspendDelay creates the delay for regular suspends.
+func newSuspendDelay() *suspendDelay {
+    return &suspendDelay{hookPreSuspend, methdRegisterSuspendDelay,
+        methdUnregisterSuspendDelay, methdHandleSuspendReadiness, 0, 0}
+}
+
+// newDarkSuspendDelay creates the delay for suspends following a dark resume.
+func newDarkSuspendDelay() *suspendDelay {
+    return &suspendDelay{hookPreDarkSuspend, methdRegisterDarkSuspendDelay,
+        methdUnregisterDarkSuspendDelay, methdHandleDarkSuspendReadiness, 0, 0}
+}
+
+// hookBudgets returns the budgets of a hook from the config. Confs without a
+// configured budget get execTimeout.
+func (manager *SuspendManager) hookBudgets(hook string) *hookutil.Budgets {
+    budgets := &hookutil.Budgets{Default: execTimeout * time.Millisecond, Confs: make(map[string]time.Duration)}
+    budget, ok := manager.cfg.HookBudgets[hook]
+    if !ok {
+        return budgets
+    }
+    if budget.DefaultMs > 0 {
+        budgets.Default = time.Duration(budget.DefaultMs) * time.Millisecond
+    }
+    for conf, ms := range budget.Confs {
+        budgets.Confs[conf] = time.Duration(ms) * time.Millisecond
+    }
+    return budgets
+}
+
+// delayTimeout returns the timeout to register for a delay: the summed budget
+// of the confs defining its hook plus a margin. Regular suspends may also wait
+// for the delay locks.
+func (manager *SuspendManager) delayTimeout(delay *suspendDelay) time.Duration {
+    margin := manager.cfg.SuspendDelayMarginMs
+    if margin <= 0 {
+        margin = defaultSuspendDelayMargin
+    }
+    confs := manager.runner.DefiningConfs(delay.hook)
+    timeout := manager.hookBudgets(delay.hook).Total(confs) + time.Duration(margin)*time.Millisecond
+    if delay == manager.delay {
+        timeout += manager.locks.maxTimeout()
+    }
+    return timeout
+}
+
+// applyConfig applies the config settings not read on demand.
+func (manager *SuspendManager) applyConfig() {
+    maxDelayLock := manager.cfg.MaxDelayLockMs
+    if maxDelayLock <= 0 {
+        maxDelayLock = defaultMaxDelayLock
+    }
+    manager.locks.setMaxTimeout(time.Duration(maxDelayLock) * time.Millisecond)
+    applyWakeupConfig(manager.cfg.Wakeup)
+}
+
+// registerDelay registers a delay with the given timeout with powerd.
+func (manager *SuspendManager) registerDelay(delay *suspendDelay, timeout time.Duration) error {
+    // powerd expects the timeout as a base::TimeDelta internal value, which is
+    // in microseconds.
+    timeoutUs := timeout.Microseconds()
+    description := serverDescription
+    req := &pmpb.RegisterSuspendDelayRequest{Timeout: &timeoutUs, Description: &description}
+    rsp := &pmpb.RegisterSuspendDelayReply{}
+
+    if err := dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(delay.methdRegister), req, rsp); err != nil {
+        return err
+    }
+    delay.id = rsp.GetDelayId()
+    delay.timeout = timeout
+    log.Printf("%s: delay %d with timeout %v", delay.methdRegister, delay.id, timeout)
+    return nil
+}
+
+// unregisterDelay unregisters a delay from powerd.
+func (manager *SuspendManager) unregisterDelay(delay *suspendDelay) error {
+    if delay.id == 0 {
+        return nil
+    }
+    req := &pmpb.UnregisterSuspendDelayRequest{DelayId: &delay.id}
+    if err := dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(delay.methdUnregister), req, nil); err != nil {
+        return err
+    }
+    delay.id = 0
+    return nil
+}
+
+// sendReadiness notifies powerd that the delay is ready for the given suspend.
+func (manager *SuspendManager) sendReadiness(delay *suspendDelay, suspendId int32) error {
+    req := &pmpb.SuspendReadinessInfo{DelayId: &delay.id, SuspendId: &suspendId}
+    return dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(delay.methdReadiness), req, nil)
+}
+
+// configSignature returns a string that changes whenever the config file or
+// one of the board confs is added, removed or modified.
+func (manager *SuspendManager) configSignature() string {
+    var sig strings.Builder
+    for _, path := range append([]string{config.PathConfig}, manager.runner.Confs()...) {
+        if fi, err := os.Stat(path); err == nil {
+            fmt.Fprintf(&sig, "%s:%d:%d;", filepath.Base(path), fi.ModTime().UnixNano(), fi.Size())
+        }
+    }
+    return sig.String()
+}
+
+// reloadConfig takes the suspend settings from a reloaded config: the hook
+// budgets, the suspend delay margin, the delay lock timeout cap and the wakeup
+// sources. No other manager reloads the config, so the other settings of the
+// file only take effect when the daemon restarts.
+func (manager *SuspendManager) reloadConfig(cfg *config.Config) {
+    manager.cfg.HookBudgets = cfg.HookBudgets
+    manager.cfg.SuspendDelayMarginMs = cfg.SuspendDelayMarginMs
+    manager.cfg.MaxDelayLockMs = cfg.MaxDelayLockMs
+    manager.cfg.Wakeup = cfg.Wakeup
+}
+
+// checkConfig reloads the suspend settings, see reloadConfig, and re-registers
+// the suspend delays when the config or the board confs changed.
+func (manager *SuspendManager) checkConfig() error {
+    signature := manager.configSignature()
+    if signature == manager.signature || manager.state != stateIdle {
+        return nil
+    }
+    log.Println("Suspend config changed, reloading the suspend settings")
+    cfg, err := config.Load()
+    if err != nil {
+        return err
+    }
+    manager.reloadConfig(cfg)
+    manager.signature = signature
+    manager.applyConfig()
+    for _, delay := range []*suspendDelay{manager.delay, manager.dark_delay} {
+        timeout := manager.delayTimeout(delay)
+        if timeout == delay.timeout {
+            continue
+        }
+        if err := manager.unregisterDelay(delay); err != nil {
+            return err
+        }
+        if err := manager.registerDelay(delay, timeout); err != nil {
+            return err
+        }
+    }
+    return nil
+}
\ No newline at end of file
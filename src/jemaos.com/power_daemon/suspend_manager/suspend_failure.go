@@ -0,0 +1,148 @@
+package suspend_manager
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/godbus/dbus/v5"
+)
+
+const (
+    // D-Bus method name for querying the suspend failure statistics.
+    methdGetSuspendStats = "GetSuspendStats"
+
+    // Board hook function run after a failed or cancelled suspend attempt.
+    hookSuspendFailed = "suspend_failed"
+
+    // Kernel suspend statistics directory.
+    pathSuspendStats = "/sys/power/suspend_stats"
+
+    // Failures of the same device after which it is reported as repeatedly failing.
+    repeatedFailureThreshold = 3
+
+    // Failure reasons reported to the hooks and recorded in the history.
+    failureKernel     = "kernel"
+    failureCancelled  = "cancelled"
+    failureIdMismatch = "id_mismatch"
+)
+
+// kernelStats is a snapshot of the kernel suspend statistics.
+type kernelStats struct {
+    fail             int
+    failed_freeze    int
+    last_failed_dev  string
+    last_failed_step string
+}
+
+// readKernelStat reads a single suspend_stats file.
+func readKernelStat(name string) string {
+    buf, err := ioutil.ReadFile(filepath.Join(pathSuspendStats, name))
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(buf))
+}
+
+// readKernelStats takes a snapshot of the kernel suspend statistics.
+func readKernelStats() kernelStats {
+    fail, _ := strconv.Atoi(readKernelStat("fail"))
+    failedFreeze, _ := strconv.Atoi(readKernelStat("failed_freeze"))
+    return kernelStats{fail, failedFreeze, readKernelStat("last_failed_dev"), readKernelStat("last_failed_step")}
+}
+
+// SuspendStats counts suspend attempts and their failures.
+type SuspendStats struct {
+    Attempts       int            `json:"attempts"`
+    Failures       int            `json:"failures"`
+    KernelFailures int            `json:"kernel_failures"`
+    FreezeFailures int            `json:"freeze_failures"`
+    Cancelled      int            `json:"cancelled"`
+    IdMismatches   int            `json:"id_mismatches"`
+    FailedDevices  map[string]int `json:"failed_devices"`
+}
+
+// suspendFailure describes why a suspend attempt failed.
+type suspendFailure struct {
+    reason string
+    device string
+    step   string
+}
+
+// failureTracker detects failed suspend attempts and keeps the statistics.
+// The statistics are read from godbus goroutines, so they are guarded by mutex.
+type failureTracker struct {
+    mutex  sync.Mutex
+    stats  SuspendStats
+    before kernelStats
+}
+
+// newFailureTracker creates a tracker with empty statistics.
+func newFailureTracker() *failureTracker {
+    return &failureTracker{stats: SuspendStats{FailedDevices: make(map[string]int)}}
+}
+
+// begin records the kernel statistics at the start of a suspend attempt.
+func (tracker *failureTracker) begin() {
+    tracker.mutex.Lock()
+    defer tracker.mutex.Unlock()
+    tracker.stats.Attempts++
+    tracker.before = readKernelStats()
+}
+
+// check classifies the suspend attempt that just finished and updates the
+// statistics. It returns nil if the attempt succeeded.
+func (tracker *failureTracker) check(durationUs int64, idMatched bool) *suspendFailure {
+    tracker.mutex.Lock()
+    defer tracker.mutex.Unlock()
+    after := readKernelStats()
+    var failure *suspendFailure
+
+    if after.fail > tracker.before.fail {
+        tracker.stats.KernelFailures++
+        if after.failed_freeze > tracker.before.failed_freeze {
+            tracker.stats.FreezeFailures++
+        }
+        failure = &suspendFailure{failureKernel, after.last_failed_dev, after.last_failed_step}
+    }
+    if durationUs == 0 {
+        tracker.stats.Cancelled++
+        if failure == nil {
+            failure = &suspendFailure{reason: failureCancelled}
+        }
+    }
+    if !idMatched {
+        tracker.stats.IdMismatches++
+        if failure == nil {
+            failure = &suspendFailure{reason: failureIdMismatch}
+        }
+    }
+    if failure == nil {
+        return nil
+    }
+
+    tracker.stats.Failures++
+    if failure.device != "" {
+        tracker.stats.FailedDevices[failure.device]++
+        if count := tracker.stats.FailedDevices[failure.device]; count >= repeatedFailureThreshold {
+            log.Printf("Device %s failed suspend %d times", failure.device, count)
+        }
+    }
+    log.Printf("Suspend attempt failed: reason: %s, device: %s, step: %s", failure.reason, failure.device, failure.step)
+    return failure
+}
+
+// get implements the GetSuspendStats D-Bus method, returning the statistics as JSON.
+func (tracker *failureTracker) get() (string, *dbus.Error) {
+    tracker.mutex.Lock()
+    defer tracker.mutex.Unlock()
+    buf, err := json.Marshal(&tracker.stats)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
\ No newline at end of file
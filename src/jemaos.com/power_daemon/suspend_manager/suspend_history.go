@@ -0,0 +1,173 @@
+package suspend_manager
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/hookutil"
+)
+
+const (
+    // D-Bus method name for querying the suspend history.
+    methdGetSuspendHistory = "GetSuspendHistory"
+
+    // File keeping the suspend history, relative to the state directory.
+    fileSuspendHistory = "suspend_history.json"
+
+    // Number of suspend cycles kept in the history file.
+    historySize = 500
+)
+
+// HookRecord is the outcome of a hook run recorded in the suspend history.
+type HookRecord struct {
+    Hook       string `json:"hook"`
+    Conf       string `json:"conf"`
+    ExitCode   int    `json:"exit_code"`
+    DurationMs int64  `json:"duration_ms"`
+    Killed     bool   `json:"killed,omitempty"`
+}
+
+// SuspendRecord describes a single suspend/resume cycle.
+type SuspendRecord struct {
+    SuspendId   int32        `json:"suspend_id"`
+    Reason      string       `json:"reason"`
+    Entered     time.Time    `json:"entered"`
+    DurationUs  int64        `json:"duration_us"`
+    WakeupType  string       `json:"wakeup_type"`
+    Wake        *WakeReason  `json:"wake,omitempty"`
+    DarkResumes int          `json:"dark_resumes"`
+    IdMatched   bool         `json:"id_matched"`
+    Failure     string       `json:"failure,omitempty"`
+    FailedDev   string       `json:"failed_dev,omitempty"`
+    Hooks       []HookRecord `json:"hooks"`
+}
+
+// suspendHistory keeps the most recent suspend cycles in a ring buffer that is
+// persisted to disk after every resume. It is read from godbus goroutines, so
+// all state is guarded by mutex.
+type suspendHistory struct {
+    mutex   sync.Mutex
+    path    string
+    records []SuspendRecord
+    current *SuspendRecord
+}
+
+// newSuspendHistory loads the suspend history from the state directory.
+func newSuspendHistory() *suspendHistory {
+    history := &suspendHistory{path: filepath.Join(config.PathStateDir, fileSuspendHistory)}
+    buf, err := ioutil.ReadFile(history.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("Read suspend history error: %v", err)
+        }
+        return history
+    }
+    if err := json.Unmarshal(buf, &history.records); err != nil {
+        log.Printf("Parse suspend history error: %v", err)
+    }
+    return history
+}
+
+// begin starts recording a new suspend cycle.
+func (history *suspendHistory) begin(suspendId int32, reason string) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+    history.current = &SuspendRecord{SuspendId: suspendId, Reason: reason, Entered: time.Now()}
+}
+
+// addHooks records hook results in the current cycle.
+func (history *suspendHistory) addHooks(results []hookutil.Result) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+    if history.current == nil {
+        return
+    }
+    for _, result := range results {
+        history.current.Hooks = append(history.current.Hooks, HookRecord{result.Hook, result.Conf,
+            result.ExitCode, result.Duration.Milliseconds(), result.Killed})
+    }
+}
+
+// resume records the resume of the current cycle.
+func (history *suspendHistory) resume(suspendId int32, durationUs int64, wakeupType string, darkResumes int, wake *WakeReason) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+    if history.current == nil {
+        return
+    }
+    history.current.IdMatched = history.current.SuspendId == suspendId
+    history.current.DurationUs = durationUs
+    history.current.WakeupType = wakeupType
+    history.current.DarkResumes = darkResumes
+    history.current.Wake = wake
+}
+
+// fail records why the current cycle failed.
+func (history *suspendHistory) fail(failure *suspendFailure) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+    if history.current == nil {
+        return
+    }
+    history.current.Failure = failure.reason
+    history.current.FailedDev = failure.device
+}
+
+// finish appends the current cycle to the ring buffer and saves it to disk.
+func (history *suspendHistory) finish() {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+    if history.current == nil {
+        return
+    }
+    history.records = append(history.records, *history.current)
+    if len(history.records) > historySize {
+        history.records = history.records[len(history.records)-historySize:]
+    }
+    history.current = nil
+    if err := history.save(); err != nil {
+        log.Printf("Save suspend history error: %v", err)
+    }
+}
+
+// save writes the ring buffer to disk. Called with mutex held.
+func (history *suspendHistory) save() error {
+    buf, err := json.Marshal(history.records)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(history.path), 0755); err != nil {
+        return err
+    }
+    tmp := history.path + ".tmp"
+    if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, history.path)
+}
+
+// get implements the GetSuspendHistory D-Bus method. It returns the most
+// recent count cycles, oldest first, as a JSON array; 0 returns all of them.
+func (history *suspendHistory) get(count uint32) (string, *dbus.Error) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+    records := history.records
+    if count > 0 && int(count) < len(records) {
+        records = records[len(records)-int(count):]
+    }
+    if records == nil {
+        records = []SuspendRecord{}
+    }
+    buf, err := json.Marshal(records)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
\ No newline at end of file
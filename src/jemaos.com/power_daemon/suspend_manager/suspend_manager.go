@@ -2,155 +2,337 @@ package suspend_manager
 
 import (
     "context"
-    "errors"
     "log"
-    "os"
-    "os/exec"
     "time"
 
     "github.com/godbus/dbus/v5"
     pmpb "chromiumos/system_api/power_manager_proto"
+    "jemaos.com/power_daemon/config"
     "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
 )
 
 const (
     // D-Bus signal names for suspend and resume events.
-    sigSuspendImminent = "SuspendImminent"
-    sigSuspendDone     = "SuspendDone"
+    sigSuspendImminent     = "SuspendImminent"
+    sigDarkSuspendImminent = "DarkSuspendImminent"
+    sigSuspendDone         = "SuspendDone"
 
     // D-Bus method names for suspend delay handling.
-    methdRegisterSuspendDelay    = "RegisterSuspendDelay"
-    methdUnregisterSuspendDelay  = "UnregisterSuspendDelay"
-    methdHandleSuspendReadiness  = "HandleSuspendReadiness"
+    methdRegisterSuspendDelay        = "RegisterSuspendDelay"
+    methdUnregisterSuspendDelay      = "UnregisterSuspendDelay"
+    methdHandleSuspendReadiness      = "HandleSuspendReadiness"
+    methdRegisterDarkSuspendDelay    = "RegisterDarkSuspendDelay"
+    methdUnregisterDarkSuspendDelay  = "UnregisterDarkSuspendDelay"
+    methdHandleDarkSuspendReadiness  = "HandleDarkSuspendReadiness"
 
-    // Paths to pre-suspend and post-resume scripts.
-    pathPreSuspendScript = "/etc/powerd/pre_suspend.sh"
-    pathPostResumeScript = "/etc/powerd/post_resume.sh"
+    // Board hook functions run before suspend, before re-suspending from a
+    // dark resume and after a full resume.
+    hookPreSuspend     = "pre_suspend"
+    hookPreDarkSuspend = "pre_dark_suspend"
+    hookPostResume     = "post_resume"
+
+    // Resume types reported to the hooks.
+    resumeTypeFull      = "full"
+    resumeTypeDark      = "dark"
+    resumeTypeRecovered = "recovered"
 
     // Description of the suspend manager.
     serverDescription = "JemaOS Suspend Manager"
 
-    // Timeout for script execution in milliseconds.
+    // Default budget of a conf's hook in milliseconds.
     execTimeout = 200
+
+    // Interval for checking whether the delay locks of a pending suspend
+    // are released.
+    lockCheckInterval = 100 * time.Millisecond
 )
 
-// SuspendManager manages suspend and resume events, including executing scripts
-// and interacting with the D-Bus Power Manager service.
+// SuspendManager manages suspend and resume events, including running board hooks
+// and interacting with the D-Bus Power Manager service. The suspend/resume flow
+// is an explicit state machine, see suspendState.
 type SuspendManager struct {
     ctx             context.Context
+    conn            *dbus.Conn
     obj             dbus.BusObject
-    delay_id        int32
+    runner          *hookutil.Runner
+    cfg             *config.Config
+    signature       string
+    delay           *suspendDelay
+    dark_delay      *suspendDelay
+    locks           *delayLocks
+    history         *suspendHistory
+    failures        *failureTracker
+    wake            *wakeTracker
+    state           suspendState
+    state_entered   time.Time
+    // Time of the last SuspendImminent, from which the delay locks expire.
+    delay_start     time.Time
+    // Unique bus name of powerd, used to detect powerd restarts.
+    powerd_owner    string
     suspend_id      int32
-    on_suspend_delay bool
+    dark_suspend_id int32
+    // Number of dark resumes since the current suspend started.
+    dark_resumes    int
+    // Last power supply state from powerd, passed to the hooks.
+    power_supply    *pmpb.PowerSupplyProperties
 }
 
-// NewSuspendManager initializes a new SuspendManager instance.
-func NewSuspendManager(ctx context.Context, conn *dbus.Conn) *SuspendManager {
-    return &SuspendManager{ctx, dbusutil.GetPMObject(conn), 0, 0, false}
+// NewSuspendManager initializes a new SuspendManager instance. It keeps its own
+// copy of cfg, as it reloads the suspend settings at runtime.
+func NewSuspendManager(ctx context.Context, conn *dbus.Conn, runner *hookutil.Runner, cfg *config.Config) *SuspendManager {
+    settings := *cfg
+    return &SuspendManager{ctx, conn, dbusutil.GetPMObject(conn), runner, &settings, "",
+        newSuspendDelay(), newDarkSuspendDelay(), newDelayLocks(conn),
+        newSuspendHistory(), newFailureTracker(), &wakeTracker{}, stateIdle, time.Now(), time.Time{}, "", 0, 0, 0, nil}
 }
 
 // sendSuspendReadiness notifies the Power Manager that the system is ready to suspend.
 func (manager *SuspendManager) sendSuspendReadiness() error {
-    req := &pmpb.SuspendReadinessInfo{DelayId: &manager.delay_id, SuspendId: &manager.suspend_id}
-    return dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(methdHandleSuspendReadiness), req, nil)
+    return manager.sendReadiness(manager.delay, manager.suspend_id)
 }
 
-// handleSuspend processes the SuspendImminent signal and executes the pre-suspend script.
+// handleSuspend processes the SuspendImminent signal and runs the pre-suspend hooks.
+// A SuspendImminent received while a previous suspend is still in progress
+// supersedes it. Readiness is sent on every path: right away on decode errors,
+// otherwise by checkLocks once the delay locks are released.
 func (manager *SuspendManager) handleSuspend(signal *dbus.Signal) error {
     log.Println("Received Suspend signal")
-    if manager.on_suspend_delay {
-        return errors.New("system is already in suspend state")
-    }
+    start := time.Now()
 
     suspendInfo := &pmpb.SuspendImminent{}
     if err := dbusutil.DecodeSignal(signal, suspendInfo); err != nil {
+        // powerd numbers suspend requests sequentially, so the next ID is the
+        // best guess for the readiness reply.
+        manager.suspend_id++
+        log.Printf("Decode SuspendImminent error, sending readiness for suspend %d", manager.suspend_id)
+        manager.sendSuspendReadiness()
         return err
     }
 
+    if manager.state != stateIdle {
+        log.Printf("Suspend %d in state %s superseded by suspend %d",
+            manager.suspend_id, manager.state, suspendInfo.GetSuspendId())
+        manager.history.finish()
+    }
+
     manager.suspend_id = suspendInfo.GetSuspendId()
-    manager.on_suspend_delay = true
+    manager.dark_resumes = 0
+    manager.delay_start = start
+    manager.setState(stateDelaying)
+    manager.history.begin(manager.suspend_id, suspendInfo.GetReason().String())
+    manager.failures.begin()
     log.Printf("On suspend: %d, reason: %s", manager.suspend_id, suspendInfo.GetReason().String())
+    applyWakeupConfig(manager.cfg.Wakeup)
+    // Let the lock holders work while the hooks run.
+    manager.locks.notify(manager.suspend_id)
 
-    if _, err := os.Stat(pathPreSuspendScript); err != nil {
-        log.Printf("The script %s does not exist.", pathPreSuspendScript)
+    // The hooks must finish within the delay registered with powerd.
+    ctx, cancel := context.WithTimeout(context.Background(), manager.delay.timeout)
+    defer cancel()
+
+    manager.runHook(ctx, hookPreSuspend, &hookContext{
+        SuspendId:     manager.suspend_id,
+        SuspendReason: suspendInfo.GetReason().String(),
+    })
+
+    return manager.checkLocks()
+}
+
+// checkLocks sends the readiness of a pending suspend once its pre-suspend
+// hooks ran and no service holds a delay lock anymore. It runs on a short
+// ticker, so waiting for the locks does not block the signal loop.
+func (manager *SuspendManager) checkLocks() error {
+    if manager.state != stateDelaying || manager.locks.pending(manager.delay_start) {
+        return nil
     }
+    manager.setState(stateSuspended)
+    manager.wake.begin()
+    return manager.sendSuspendReadiness()
+}
 
-    ctx, cancel := context.WithTimeout(context.Background(), execTimeout*time.Millisecond)
-    defer cancel()
-    defer manager.sendSuspendReadiness()
+// handleDarkSuspend processes the DarkSuspendImminent signal, which powerd
+// emits when it is about to re-suspend after a dark resume, and runs the
+// pre-dark-suspend hooks.
+func (manager *SuspendManager) handleDarkSuspend(signal *dbus.Signal) error {
+    log.Println("Received Dark Suspend signal")
+    suspendInfo := &pmpb.SuspendImminent{}
+    if err := dbusutil.DecodeSignal(signal, suspendInfo); err != nil {
+        manager.dark_suspend_id++
+        log.Printf("Decode DarkSuspendImminent error, sending readiness for dark suspend %d", manager.dark_suspend_id)
+        manager.sendReadiness(manager.dark_delay, manager.dark_suspend_id)
+        return err
+    }
 
-    if err := exec.CommandContext(ctx, pathPreSuspendScript).Run(); err != nil {
-        log.Printf("Error executing pre-suspend script: %v", err)
+    darkSuspendId := suspendInfo.GetSuspendId()
+    manager.dark_suspend_id = darkSuspendId
+    defer manager.sendReadiness(manager.dark_delay, darkSuspendId)
+    if manager.state != stateSuspended {
+        log.Printf("Dark suspend %d in state %s", darkSuspendId, manager.state)
     }
+
+    manager.dark_resumes++
+    manager.setState(stateDarkResumed)
+    log.Printf("On dark suspend: %d, dark resumes: %d", darkSuspendId, manager.dark_resumes)
+
+    ctx, cancel := context.WithTimeout(context.Background(), manager.dark_delay.timeout)
+    defer cancel()
+
+    manager.runHook(ctx, hookPreDarkSuspend, &hookContext{
+        SuspendId:       darkSuspendId,
+        SuspendReason:   suspendInfo.GetReason().String(),
+        ResumeType:      resumeTypeDark,
+        DarkResumeCount: manager.dark_resumes,
+    })
+    manager.setState(stateSuspended)
+    // Attribute the final wake, not the dark resumes before it.
+    manager.wake.begin()
     return nil
 }
 
-// handleResume processes the SuspendDone signal and executes the post-resume script.
+// handleResume processes the SuspendDone signal and runs the post-resume hooks.
 func (manager *SuspendManager) handleResume(signal *dbus.Signal) error {
     log.Println("Received Resume signal")
-    if !manager.on_suspend_delay {
-        return errors.New("system is not in suspend state")
-    }
-
     suspendInfo := &pmpb.SuspendDone{}
     if err := dbusutil.DecodeSignal(signal, suspendInfo); err != nil {
+        if manager.state != stateIdle {
+            manager.recover()
+        }
         return err
     }
 
-    if suspendInfo.GetSuspendId() != manager.suspend_id {
+    idMatched := suspendInfo.GetSuspendId() == manager.suspend_id
+    if manager.state == stateIdle {
+        log.Printf("Resume of suspend %d without a known suspend", suspendInfo.GetSuspendId())
+        manager.history.begin(suspendInfo.GetSuspendId(), "")
+        manager.failures.begin()
+        idMatched = false
+    } else if !idMatched {
         log.Println("The resume suspend ID is different from the original")
     }
 
-    manager.suspend_id = 0
-    manager.on_suspend_delay = false
+    wake := manager.wake.attribute(suspendInfo.GetWakeupType().String())
+    hc := &hookContext{
+        SuspendId:         suspendInfo.GetSuspendId(),
+        WakeupType:        suspendInfo.GetWakeupType().String(),
+        WakeSource:        wake.Source,
+        WakeDevice:        wake.Device,
+        WakeIrq:           wake.Irq,
+        SuspendDurationUs: suspendInfo.GetSuspendDuration(),
+        ResumeType:        resumeTypeFull,
+        DarkResumeCount:   manager.dark_resumes,
+    }
+    manager.history.resume(suspendInfo.GetSuspendId(), suspendInfo.GetSuspendDuration(),
+        suspendInfo.GetWakeupType().String(), manager.dark_resumes, wake)
+    manager.setState(stateResuming)
     log.Printf("Resume complete: duration: %d, wakeup type: %s", suspendInfo.GetSuspendDuration(), suspendInfo.GetWakeupType().String())
 
-    if _, err := os.Stat(pathPostResumeScript); err != nil {
-        log.Printf("The script %s does not exist.", pathPostResumeScript)
+    if failure := manager.failures.check(suspendInfo.GetSuspendDuration(), idMatched); failure != nil {
+        manager.history.fail(failure)
+        manager.runHook(manager.ctx, hookSuspendFailed, &hookContext{
+            SuspendId:     suspendInfo.GetSuspendId(),
+            FailureReason: failure.reason,
+            FailedDevice:  failure.device,
+            FailedStep:    failure.step,
+        })
     }
 
-    ctx, cancel := context.WithTimeout(context.Background(), execTimeout*time.Millisecond)
-    defer cancel()
+    manager.runHook(manager.ctx, hookPostResume, hc)
+    manager.history.finish()
+    manager.setState(stateIdle)
+    return nil
+}
 
-    if err := exec.CommandContext(ctx, pathPostResumeScript).Run(); err != nil {
-        log.Printf("Error executing post-resume script: %v", err)
+// checkPowerd re-registers the suspend delays when powerd restarted, since
+// the restarted powerd does not know them, and recovers a suspend in progress.
+func (manager *SuspendManager) checkPowerd() error {
+    owner, err := dbusutil.GetNameOwner(manager.conn, dbusutil.PowerManagerName)
+    if err != nil || owner == manager.powerd_owner {
+        return nil
+    }
+    if manager.powerd_owner == "" {
+        manager.powerd_owner = owner
+        return nil
+    }
+    log.Printf("powerd restarted as %s", owner)
+    manager.powerd_owner = owner
+    if manager.state != stateIdle {
+        manager.recover()
+    }
+    for _, delay := range []*suspendDelay{manager.delay, manager.dark_delay} {
+        if err := manager.registerDelay(delay, delay.timeout); err != nil {
+            return err
+        }
     }
     return nil
 }
 
+// checkTimeouts runs the periodic lifecycle checks.
+func (manager *SuspendManager) checkTimeouts() error {
+    if err := manager.checkPowerd(); err != nil {
+        return err
+    }
+    return manager.checkState()
+}
+
 // Register registers the suspend manager with the D-Bus signal server and sets up handlers.
 func (manager *SuspendManager) Register(sigServer *dbusutil.SignalServer) error {
-    timeout := int64(execTimeout)
-    description := serverDescription
-    req := &pmpb.RegisterSuspendDelayRequest{Timeout: &timeout, Description: &description}
-    rsp := &pmpb.RegisterSuspendDelayReply{}
-
-    if err := dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(methdRegisterSuspendDelay), req, rsp); err != nil {
+    manager.signature = manager.configSignature()
+    manager.applyConfig()
+    manager.powerd_owner, _ = dbusutil.GetNameOwner(manager.conn, dbusutil.PowerManagerName)
+    manager.queryPowerSupply()
+    if err := manager.registerDelay(manager.delay, manager.delayTimeout(manager.delay)); err != nil {
+        return err
+    }
+    if err := manager.registerDelay(manager.dark_delay, manager.delayTimeout(manager.dark_delay)); err != nil {
         return err
     }
-
-    manager.delay_id = rsp.GetDelayId()
 
     suspendHandler := func(sig *dbus.Signal) error {
         return manager.handleSuspend(sig)
     }
+    darkSuspendHandler := func(sig *dbus.Signal) error {
+        return manager.handleDarkSuspend(sig)
+    }
     resumeHandler := func(sig *dbus.Signal) error {
         return manager.handleResume(sig)
     }
+    powerSupplyHandler := func(sig *dbus.Signal) error {
+        return manager.handlePowerSupplyPoll(sig)
+    }
 
     sigServer.RegisterSignalHandler(sigSuspendImminent, suspendHandler)
+    sigServer.RegisterSignalHandler(sigDarkSuspendImminent, darkSuspendHandler)
     sigServer.RegisterSignalHandler(sigSuspendDone, resumeHandler)
+    sigServer.RegisterSignalHandler(sigPowerSupplyPoll, powerSupplyHandler)
+    sigServer.RegisterTicker(configCheckInterval, manager.checkConfig)
+    sigServer.RegisterTicker(stateCheckInterval, manager.checkTimeouts)
+    sigServer.RegisterTicker(lockCheckInterval, manager.checkLocks)
+    manager.locks.start()
 
     log.Println("Suspend manager registered")
     return nil
 }
 
+// RegisterMethods registers the delay lock, suspend history and suspend
+// statistics D-Bus methods with the service server.
+// Other services call AcquireSuspendDelay(name, timeout_ms) to take a named
+// lock; on SuspendImminent the daemon emits its own SuspendImminent signal and
+// waits until every lock is released with ReleaseSuspendDelay(id), its
+// timeout passes or its holder leaves the bus.
+func (manager *SuspendManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdAcquireSuspendDelay, manager.locks.acquire)
+    service.RegisterMethod(methdReleaseSuspendDelay, manager.locks.release)
+    service.RegisterMethod(methdGetSuspendHistory, manager.history.get)
+    service.RegisterMethod(methdGetSuspendStats, manager.failures.get)
+}
+
 // UnRegister unregisters the suspend manager from the D-Bus signal server.
 func (manager *SuspendManager) UnRegister(sigServer *dbusutil.SignalServer) error {
-    if manager.delay_id != 0 {
-        req := &pmpb.UnregisterSuspendDelayRequest{DelayId: &manager.delay_id}
-        log.Println("Unregistering suspend manager")
-        return dbusutil.CallProtoMethod(manager.ctx, manager.obj, dbusutil.GetPMMethod(methdUnregisterSuspendDelay), req, nil)
+    log.Println("Unregistering suspend manager")
+    manager.locks.stop()
+    if err := manager.unregisterDelay(manager.dark_delay); err != nil {
+        log.Printf("Unregister dark suspend delay error: %v", err)
     }
-    return nil
+    return manager.unregisterDelay(manager.delay)
 }
\ No newline at end of file
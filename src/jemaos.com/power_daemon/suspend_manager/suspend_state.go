@@ -0,0 +1,82 @@
+package suspend_manager
+
+import (
+    "log"
+    "time"
+)
+
+const (
+    // Interval for checking whether the current state timed out.
+    stateCheckInterval = 5 * time.Second
+
+    // Awake time after which a suspend without SuspendDone is given up. The
+    // monotonic clock does not advance while the system is suspended, so this
+    // only counts time spent awake waiting for a lost SuspendDone.
+    suspendedTimeout = 60 * time.Second
+)
+
+// suspendState is a state of the suspend/resume lifecycle.
+type suspendState int
+
+const (
+    // stateIdle: no suspend in progress.
+    stateIdle suspendState = iota
+    // stateDelaying: running pre_suspend hooks and waiting for delay locks.
+    stateDelaying
+    // stateSuspended: readiness sent, waiting for SuspendDone.
+    stateSuspended
+    // stateDarkResumed: running pre_dark_suspend hooks after a dark resume.
+    stateDarkResumed
+    // stateResuming: running post_resume hooks.
+    stateResuming
+)
+
+// String returns the name of the state.
+func (state suspendState) String() string {
+    switch state {
+    case stateIdle:
+        return "idle"
+    case stateDelaying:
+        return "delaying"
+    case stateSuspended:
+        return "suspended"
+    case stateDarkResumed:
+        return "dark-resumed"
+    case stateResuming:
+        return "resuming"
+    }
+    return "unknown"
+}
+
+// setState moves the lifecycle to a new state.
+func (manager *SuspendManager) setState(state suspendState) {
+    if state == manager.state {
+        return
+    }
+    log.Printf("Suspend state: %s -> %s", manager.state, state)
+    manager.state = state
+    manager.state_entered = time.Now()
+}
+
+// checkState recovers from a SuspendDone that never arrived, e.g. because
+// powerd restarted, by running the post-resume hooks and returning to idle.
+func (manager *SuspendManager) checkState() error {
+    if manager.state != stateSuspended || time.Since(manager.state_entered) < suspendedTimeout {
+        return nil
+    }
+    log.Printf("No SuspendDone for suspend %d after %v, recovering", manager.suspend_id, suspendedTimeout)
+    manager.recover()
+    return nil
+}
+
+// recover finishes the current suspend without a SuspendDone.
+func (manager *SuspendManager) recover() {
+    manager.setState(stateResuming)
+    manager.runHook(manager.ctx, hookPostResume, &hookContext{
+        SuspendId:       manager.suspend_id,
+        ResumeType:      resumeTypeRecovered,
+        DarkResumeCount: manager.dark_resumes,
+    })
+    manager.history.finish()
+    manager.setState(stateIdle)
+}
\ No newline at end of file
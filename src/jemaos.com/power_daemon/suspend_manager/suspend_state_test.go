@@ -0,0 +1,128 @@
+package suspend_manager
+
+import (
+    "context"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/hookutil"
+    "jemaos.com/power_daemon/sysfsutil/sysfstest"
+)
+
+// newTestManager returns a manager without a bus connection, running the
+// confs of a temporary board directory and with a temporary state directory.
+// The board directory is returned.
+func newTestManager(t *testing.T, confs map[string]string) (*SuspendManager, string) {
+    dir := t.TempDir()
+    sysfstest.WriteFiles(t, dir, confs)
+    sysfstest.StateDir(t)
+    return &SuspendManager{ctx: context.Background(), runner: hookutil.NewBoardRunner(dir), cfg: &config.Config{},
+        delay: newSuspendDelay(), dark_delay: newDarkSuspendDelay(), locks: newDelayLocks(nil),
+        history: newSuspendHistory(), failures: newFailureTracker(), wake: &wakeTracker{},
+        state: stateIdle, state_entered: time.Now()}, dir
+}
+
+func TestSetState(t *testing.T) {
+    manager, _ := newTestManager(t, nil)
+    entered := time.Now().Add(-time.Minute)
+    manager.state_entered = entered
+    manager.setState(stateIdle)
+    if !manager.state_entered.Equal(entered) {
+        t.Errorf("Got state entered %v, want %v kept for the same state", manager.state_entered, entered)
+    }
+    for _, state := range []suspendState{stateDelaying, stateSuspended, stateDarkResumed, stateSuspended,
+        stateResuming, stateIdle} {
+        manager.state_entered = entered
+        manager.setState(state)
+        if manager.state != state || !manager.state_entered.After(entered) {
+            t.Errorf("Got state %s entered %v, want %s entered now", manager.state, manager.state_entered, state)
+        }
+    }
+}
+
+func TestCheckState(t *testing.T) {
+    tests := []struct {
+        name      string
+        state     suspendState
+        entered   time.Duration
+        want      suspendState
+        recovered bool
+    }{
+        {"idle", stateIdle, 2 * suspendedTimeout, stateIdle, false},
+        {"suspended", stateSuspended, suspendedTimeout / 2, stateSuspended, false},
+        {"suspended without SuspendDone", stateSuspended, suspendedTimeout + time.Second, stateIdle, true},
+        {"delaying", stateDelaying, 2 * suspendedTimeout, stateDelaying, false},
+        {"dark resumed", stateDarkResumed, 2 * suspendedTimeout, stateDarkResumed, false},
+        {"resuming", stateResuming, 2 * suspendedTimeout, stateResuming, false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            // The recovery runs post_resume, which records its environment.
+            manager, dir := newTestManager(t, map[string]string{"a.conf": `post_resume() {
+    env | grep ^POWERD_ > "$(dirname "${BASH_SOURCE[0]}")/env"
+}`})
+            manager.suspend_id = 7
+            manager.history.begin(manager.suspend_id, "IDLE")
+            manager.state = test.state
+            manager.state_entered = time.Now().Add(-test.entered)
+            if err := manager.checkState(); err != nil {
+                t.Fatalf("Got error: %v", err)
+            }
+            if manager.state != test.want {
+                t.Errorf("Got state %s, want %s", manager.state, test.want)
+            }
+            records := len(manager.history.records)
+            if recovered := records == 1; recovered != test.recovered {
+                t.Errorf("Got %d finished suspends, want recovered %v", records, test.recovered)
+            }
+            buf, err := ioutil.ReadFile(filepath.Join(dir, "env"))
+            if !test.recovered {
+                if err == nil {
+                    t.Errorf("Got post_resume run with %q", buf)
+                }
+                return
+            }
+            if record := manager.history.records[0]; record.SuspendId != manager.suspend_id || len(record.Hooks) != 1 {
+                t.Errorf("Got finished suspend %+v, want %d with the post_resume run", record, manager.suspend_id)
+            }
+            for _, want := range []string{"POWERD_EVENT=" + hookPostResume, "POWERD_SUSPEND_ID=7",
+                "POWERD_RESUME_TYPE=" + resumeTypeRecovered} {
+                if !strings.Contains(string(buf), want+"\n") {
+                    t.Errorf("Got post_resume environment %q, want %s", buf, want)
+                }
+            }
+        })
+    }
+}
+
+func TestCheckLocksWhileHeld(t *testing.T) {
+    manager, _ := newTestManager(t, nil)
+    manager.setState(stateDelaying)
+    manager.delay_start = time.Now()
+    manager.locks.locks[1] = &delayLock{1, "backup", ":1.42", time.Minute}
+    // Readiness waits for the lock, without blocking the caller.
+    start := time.Now()
+    if err := manager.checkLocks(); err != nil {
+        t.Fatalf("Got error: %v", err)
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("checkLocks blocked for %v", elapsed)
+    }
+    if manager.state != stateDelaying {
+        t.Errorf("Got state %s, want %s", manager.state, stateDelaying)
+    }
+
+    // Outside of a pending suspend the locks are not looked at.
+    manager.setState(stateSuspended)
+    manager.delay_start = time.Now().Add(-time.Hour)
+    if err := manager.checkLocks(); err != nil || manager.state != stateSuspended {
+        t.Errorf("Got state %s, error: %v, want %s", manager.state, err, stateSuspended)
+    }
+    if len(manager.locks.locks) != 1 {
+        t.Errorf("Got %d locks, want the lock kept", len(manager.locks.locks))
+    }
+}
\ No newline at end of file
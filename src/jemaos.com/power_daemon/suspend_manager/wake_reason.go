@@ -0,0 +1,142 @@
+package suspend_manager
+
+import (
+    "bufio"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "jemaos.com/power_daemon/sysfsutil"
+)
+
+const (
+    // Kernel wakeup sources, the IRQ that woke the system and the interrupt table.
+    pathWakeupSources  = "/sys/class/wakeup/wakeup*"
+    pathPmWakeupIrq    = "/sys/power/pm_wakeup_irq"
+    pathProcInterrupts = "/proc/interrupts"
+
+    // powerd wakeup type of wakes by an input device.
+    wakeupTypeInput = "INPUT"
+)
+
+// wakeupSource is a snapshot of a kernel wakeup source.
+type wakeupSource struct {
+    name    string
+    device  string
+    events  int
+    wakeups int
+}
+
+// WakeReason names the device that woke the system.
+type WakeReason struct {
+    // Source is the kernel wakeup source, e.g. "PNP0C0D:00" or "1-1".
+    Source  string `json:"source,omitempty"`
+    // Device is the sysfs path of the device behind the wakeup source.
+    Device  string `json:"device,omitempty"`
+    Irq     int    `json:"irq,omitempty"`
+    IrqName string `json:"irq_name,omitempty"`
+}
+
+// readWakeupSources takes a snapshot of the kernel wakeup sources, keyed by
+// their directory name.
+func readWakeupSources() map[string]wakeupSource {
+    sources := make(map[string]wakeupSource)
+    dirs, _ := filepath.Glob(pathWakeupSources)
+    for _, dir := range dirs {
+        name, _ := sysfsutil.Read(filepath.Join(dir, "name"))
+        events, _ := sysfsutil.Read(filepath.Join(dir, "event_count"))
+        wakeups, _ := sysfsutil.Read(filepath.Join(dir, "wakeup_count"))
+        device, _ := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+        source := wakeupSource{name: name, device: device}
+        source.events, _ = strconv.Atoi(events)
+        source.wakeups, _ = strconv.Atoi(wakeups)
+        sources[filepath.Base(dir)] = source
+    }
+    return sources
+}
+
+// irqName returns the name of an IRQ from the interrupt table.
+func irqName(irq int) string {
+    file, err := os.Open(pathProcInterrupts)
+    if err != nil {
+        return ""
+    }
+    defer file.Close()
+    prefix := strconv.Itoa(irq) + ":"
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) > 1 && fields[0] == prefix {
+            return fields[len(fields)-1]
+        }
+    }
+    return ""
+}
+
+// hasInput reports whether a device has an input device below it.
+func hasInput(device string) bool {
+    if device == "" {
+        return false
+    }
+    matches, _ := filepath.Glob(filepath.Join(device, "input*"))
+    return len(matches) > 0
+}
+
+// wakeTracker attributes a wake to a device from the wakeup source counters.
+// It is only used from the signal goroutine.
+type wakeTracker struct {
+    before map[string]wakeupSource
+}
+
+// begin takes the snapshot of the wakeup sources before the system sleeps.
+func (tracker *wakeTracker) begin() {
+    tracker.before = readWakeupSources()
+}
+
+// attribute names the device that woke the system. Sources whose wakeup count
+// grew rank before those whose event count grew; on a tie an input device is
+// preferred if powerd reports an input wake. The IRQ is named from
+// pm_wakeup_irq where the kernel reports it.
+func (tracker *wakeTracker) attribute(wakeupType string) *WakeReason {
+    reason := &WakeReason{}
+    var best *wakeupSource
+    bestWakeups, bestEvents := 0, 0
+    for key, after := range readWakeupSources() {
+        // Without a snapshot, e.g. after a daemon restart, nothing can be told.
+        before, ok := tracker.before[key]
+        if !ok {
+            continue
+        }
+        wakeups, events := after.wakeups-before.wakeups, after.events-before.events
+        if wakeups <= 0 && events <= 0 {
+            continue
+        }
+        better := best == nil || wakeups > bestWakeups
+        if !better && wakeups == bestWakeups {
+            input := wakeupType == wakeupTypeInput && hasInput(after.device)
+            bestInput := wakeupType == wakeupTypeInput && hasInput(best.device)
+            better = (input && !bestInput) || (input == bestInput && events > bestEvents)
+        }
+        if better {
+            source := after
+            best, bestWakeups, bestEvents = &source, wakeups, events
+        }
+    }
+    if best != nil {
+        reason.Source, reason.Device = best.name, best.device
+    }
+    if value, err := sysfsutil.Read(pathPmWakeupIrq); err == nil {
+        if irq, err := strconv.Atoi(value); err == nil {
+            reason.Irq, reason.IrqName = irq, irqName(irq)
+        }
+    }
+    if reason.Source == "" {
+        reason.Source = reason.IrqName
+    }
+    tracker.before = nil
+    log.Printf("Woken by %q, device: %s, irq: %d %s, wakeup type: %s",
+        reason.Source, reason.Device, reason.Irq, reason.IrqName, wakeupType)
+    return reason
+}
\ No newline at end of file
@@ -0,0 +1,112 @@
+package suspend_manager
+
+import (
+    "bufio"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/sysfsutil"
+)
+
+const (
+    // ACPI wakeup device table. Writing a device name toggles it.
+    pathAcpiWakeup = "/proc/acpi/wakeup"
+
+    // Wakeup attribute of a sysfs device and its values.
+    attrPowerWakeup = "power/wakeup"
+    wakeupEnabled   = "enabled"
+    wakeupDisabled  = "disabled"
+)
+
+// readAcpiWakeup returns whether each device in the ACPI wakeup table is enabled.
+func readAcpiWakeup() (map[string]bool, error) {
+    file, err := os.Open(pathAcpiWakeup)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    states := make(map[string]bool)
+    scanner := bufio.NewScanner(file)
+    // Device  S-state   Status   Sysfs node
+    // XHC       S3    *enabled   pci:0000:00:14.0
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) < 3 || fields[0] == "Device" {
+            continue
+        }
+        states[fields[0]] = strings.TrimPrefix(fields[2], "*") == wakeupEnabled
+    }
+    return states, scanner.Err()
+}
+
+// applyAcpiWakeup toggles the ACPI wakeup devices whose state differs from
+// the config.
+func applyAcpiWakeup(devices map[string]bool) {
+    if len(devices) == 0 {
+        return
+    }
+    states, err := readAcpiWakeup()
+    if err != nil {
+        log.Printf("Read %s error: %v", pathAcpiWakeup, err)
+        return
+    }
+    for name, enable := range devices {
+        state, ok := states[name]
+        if !ok {
+            log.Printf("ACPI wakeup device %s not found", name)
+            continue
+        }
+        if state == enable {
+            continue
+        }
+        if err := ioutil.WriteFile(pathAcpiWakeup, []byte(name), 0644); err != nil {
+            log.Printf("Toggle ACPI wakeup device %s error: %v", name, err)
+            continue
+        }
+        log.Printf("Set ACPI wakeup device %s to %v", name, enable)
+    }
+    if states, err := readAcpiWakeup(); err == nil {
+        for name, enable := range devices {
+            if state, ok := states[name]; ok && state != enable {
+                log.Printf("ACPI wakeup device %s is %v after toggling", name, state)
+            }
+        }
+    }
+}
+
+// applyDeviceWakeup sets the wakeup attribute of the configured sysfs devices.
+func applyDeviceWakeup(devices map[string]bool) {
+    patterns := make([]string, 0, len(devices))
+    for pattern := range devices {
+        patterns = append(patterns, pattern)
+    }
+    sort.Strings(patterns)
+    for _, pattern := range patterns {
+        value := wakeupDisabled
+        if devices[pattern] {
+            value = wakeupEnabled
+        }
+        paths, _ := filepath.Glob(filepath.Join(pattern, attrPowerWakeup))
+        if len(paths) == 0 {
+            log.Printf("No wakeup capable device matches %s", pattern)
+        }
+        for _, path := range paths {
+            if _, err := sysfsutil.Set(path, value); err != nil {
+                log.Printf("Set %s error: %v", path, err)
+            }
+        }
+    }
+}
+
+// applyWakeupConfig enables or disables the configured wakeup sources. It runs
+// at startup, on config changes and before every suspend, to cover devices
+// plugged in meanwhile.
+func applyWakeupConfig(cfg config.WakeupConfig) {
+    applyDeviceWakeup(cfg.Devices)
+    applyAcpiWakeup(cfg.Acpi)
+}
\ No newline at end of file
@@ -0,0 +1,38 @@
+// Package sysfstest builds fake sysfs trees and state directories for tests.
+package sysfstest
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "jemaos.com/power_daemon/config"
+)
+
+// WriteFiles creates the given files, relative to root, ending in a newline
+// like sysfs attributes.
+func WriteFiles(t testing.TB, root string, files map[string]string) {
+    t.Helper()
+    for path, value := range files {
+        path = filepath.Join(root, path)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := ioutil.WriteFile(path, []byte(value+"\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// StateDir points config.PathStateDir to a temporary directory until the test
+// finishes, and returns it.
+func StateDir(t testing.TB) string {
+    t.Helper()
+    dir, saved := t.TempDir(), config.PathStateDir
+    config.PathStateDir = dir
+    t.Cleanup(func() {
+        config.PathStateDir = saved
+    })
+    return dir
+}
\ No newline at end of file
@@ -0,0 +1,114 @@
+package sysfsutil
+
+import (
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "strings"
+)
+
+// Change records a value written to a sysfs attribute and the value it
+// replaced, so that it can be reverted.
+type Change struct {
+    Path     string `json:"path"`
+    Value    string `json:"value"`
+    Previous string `json:"previous"`
+}
+
+// Read reads a sysfs attribute with surrounding whitespace removed.
+func Read(path string) (string, error) {
+    buf, err := ioutil.ReadFile(path)
+    return strings.TrimSpace(string(buf)), err
+}
+
+// Selected returns the active choice of an attribute that lists all choices
+// with the active one in brackets, e.g. "s2idle [deep]", or the value itself.
+func Selected(value string) string {
+    start, end := strings.Index(value, "["), strings.Index(value, "]")
+    if start < 0 || end < start {
+        return value
+    }
+    return value[start+1 : end]
+}
+
+// Write writes value to a sysfs attribute and verifies that it reads back.
+func Write(path, value string) error {
+    if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+        return err
+    }
+    current, err := Read(path)
+    if err != nil {
+        return err
+    }
+    if current != value && Selected(current) != value {
+        return fmt.Errorf("%s is %q after writing %q", path, current, value)
+    }
+    return nil
+}
+
+// Set writes value to a sysfs attribute unless it already has it, and returns
+// the change for Revert, or nil if nothing was written.
+func Set(path, value string) (*Change, error) {
+    current, err := Read(path)
+    if err != nil {
+        return nil, err
+    }
+    previous := Selected(current)
+    if previous == value {
+        return nil, nil
+    }
+    if err := Write(path, value); err != nil {
+        return nil, err
+    }
+    log.Printf("Set %s to %s, was %s", path, value, previous)
+    return &Change{path, value, previous}, nil
+}
+
+// Revert writes back the previous values of changes, newest first. Attributes
+// changed by someone else in the meantime are left alone.
+func Revert(changes []Change) error {
+    var errs []error
+    for i := len(changes) - 1; i >= 0; i-- {
+        change := changes[i]
+        if current, err := Read(change.Path); err != nil || Selected(current) != change.Value {
+            log.Printf("Skip reverting %s, changed since", change.Path)
+            continue
+        }
+        if err := Write(change.Path, change.Previous); err != nil {
+            errs = append(errs, err)
+            continue
+        }
+        log.Printf("Reverted %s to %s", change.Path, change.Previous)
+    }
+    return errors.Join(errs...)
+}
+
+// Changes tracks the values written to sysfs attributes, with the values they
+// replaced, so that they can be reverted and persisted.
+type Changes []Change
+
+// Set writes value to a sysfs attribute like Set and tracks the change. It
+// reports whether the attribute was written. A path written again, e.g. after
+// a device came back from resume with its default, keeps the value it had
+// first.
+func (changes *Changes) Set(path, value string) (bool, error) {
+    change, err := Set(path, value)
+    if err != nil || change == nil {
+        return false, err
+    }
+    for _, tracked := range *changes {
+        if tracked.Path == path {
+            return true, nil
+        }
+    }
+    *changes = append(*changes, *change)
+    return true, nil
+}
+
+// Revert writes back the replaced values like Revert and stops tracking them.
+func (changes *Changes) Revert() error {
+    err := Revert(*changes)
+    *changes = nil
+    return err
+}
\ No newline at end of file
@@ -0,0 +1,52 @@
+package sysfsutil
+
+import (
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "jemaos.com/power_daemon/sysfsutil/sysfstest"
+)
+
+func TestChanges(t *testing.T) {
+    root := t.TempDir()
+    sysfstest.WriteFiles(t, root, map[string]string{"control": "on", "policy": "max_performance"})
+    control, policy := filepath.Join(root, "control"), filepath.Join(root, "policy")
+    var changes Changes
+    for _, set := range []struct {
+        path, value string
+        want        bool
+    }{
+        {control, "auto", true},
+        {policy, "med_power_with_dipm", true},
+        {control, "auto", false},
+    } {
+        if got, err := changes.Set(set.path, set.value); err != nil || got != set.want {
+            t.Errorf("Set %s to %s got %v, error: %v, want %v", set.path, set.value, got, err, set.want)
+        }
+    }
+    // The attribute comes back with another value and is written again,
+    // keeping the value it had first.
+    sysfstest.WriteFiles(t, root, map[string]string{"control": "off"})
+    if got, err := changes.Set(control, "auto"); err != nil || !got {
+        t.Errorf("Set %s again got %v, error: %v", control, got, err)
+    }
+    want := Changes{{control, "auto", "on"}, {policy, "med_power_with_dipm", "max_performance"}}
+    if !reflect.DeepEqual(changes, want) {
+        t.Fatalf("Got changes %v, want %v", changes, want)
+    }
+
+    // Attributes changed by someone else are left alone.
+    sysfstest.WriteFiles(t, root, map[string]string{"policy": "min_power"})
+    if err := changes.Revert(); err != nil {
+        t.Errorf("Got error: %v", err)
+    }
+    if changes != nil {
+        t.Errorf("Got changes %v after revert, want none", changes)
+    }
+    for path, want := range map[string]string{control: "on", policy: "min_power"} {
+        if got, _ := Read(path); got != want {
+            t.Errorf("Got %s %q after revert, want %q", path, got, want)
+        }
+    }
+}
\ No newline at end of file
@@ -0,0 +1,353 @@
+package thermal_manager
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/backlight_manager"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/hookutil"
+    "jemaos.com/power_daemon/power_profile_manager"
+    "jemaos.com/power_daemon/sysfsutil"
+)
+
+const (
+    // D-Bus method name for querying the thermal state.
+    methdGetThermalState = "GetThermalState"
+
+    // Board hook functions run when a zone changes level.
+    hookThermalNormal = "thermal_normal"
+    hookThermalWarm   = "thermal_warm"
+    hookThermalHot    = "thermal_hot"
+
+    // Owner of the screen brightness cap and the power profile hold.
+    ownerThermal = "thermal"
+
+    // Thermal zones and cooling devices, relative to the sysfs root.
+    pathThermalZones   = "class/thermal/thermal_zone*"
+    pathCoolingDevices = "class/thermal/cooling_device*"
+
+    // Defaults of the thermal config.
+    defaultPollInterval = 5 * time.Second
+    defaultHysteresisC  = 3.0
+    defaultWarmC        = 70.0
+    defaultHotC         = 85.0
+
+    // Number of samples kept in the temperature history, an hour at the
+    // default poll interval.
+    thermalHistorySize = 720
+
+    // Timeout for hook execution in milliseconds.
+    execTimeout = 2000
+)
+
+// level is the thermal level of a zone.
+type level int
+
+const (
+    levelNormal level = iota
+    levelWarm
+    levelHot
+)
+
+// String returns the name of the level.
+func (l level) String() string {
+    return [...]string{"normal", "warm", "hot"}[l]
+}
+
+// hook returns the board hook run when a zone enters the level.
+func (l level) hook() string {
+    return [...]string{hookThermalNormal, hookThermalWarm, hookThermalHot}[l]
+}
+
+// ThermalZone is the state of a thermal zone.
+type ThermalZone struct {
+    Name  string  `json:"name"`
+    Type  string  `json:"type"`
+    TempC float64 `json:"temp_c"`
+    Level string  `json:"level"`
+    WarmC float64 `json:"warm_c"`
+    HotC  float64 `json:"hot_c"`
+    level level
+}
+
+// CoolingDevice is the state of a cooling device.
+type CoolingDevice struct {
+    Name     string `json:"name"`
+    Type     string `json:"type"`
+    CurState int    `json:"cur_state"`
+    MaxState int    `json:"max_state"`
+}
+
+// ThermalSample is a single poll of the zone temperatures and the cooling
+// device states, keyed by name.
+type ThermalSample struct {
+    Time    int64              `json:"t"`
+    Temps   map[string]float64 `json:"temps"`
+    Cooling map[string]int     `json:"cooling,omitempty"`
+}
+
+// ThermalState is the reply of the GetThermalState D-Bus method.
+type ThermalState struct {
+    Zones          []*ThermalZone  `json:"zones"`
+    CoolingDevices []CoolingDevice `json:"cooling_devices"`
+    History        []ThermalSample `json:"history"`
+}
+
+// ThermalManager polls the thermal zones, runs the board thermal hooks when a
+// zone changes level, and keeps the temperature history. Its D-Bus method is
+// called from godbus goroutines, so all state is guarded by mutex.
+type ThermalManager struct {
+    ctx       context.Context
+    runner    *hookutil.Runner
+    backlight *backlight_manager.ScreenBrightnessManager
+    profile   *power_profile_manager.PowerProfileManager
+    cfg       config.ThermalConfig
+    root      string
+    mutex     sync.Mutex
+    zones     []*ThermalZone
+    cooling   []CoolingDevice
+    history   []ThermalSample
+    hot       bool
+}
+
+// NewThermalManager initializes a new ThermalManager instance working on the
+// sysfs tree at root, normally config.PathSysfs.
+func NewThermalManager(ctx context.Context, cfg *config.Config, runner *hookutil.Runner,
+    backlight *backlight_manager.ScreenBrightnessManager, profile *power_profile_manager.PowerProfileManager,
+    root string) *ThermalManager {
+    manager := &ThermalManager{ctx: ctx, runner: runner, backlight: backlight, profile: profile,
+        cfg: cfg.Thermal, root: root}
+    if manager.cfg.HysteresisC <= 0 {
+        manager.cfg.HysteresisC = defaultHysteresisC
+    }
+    if manager.cfg.Default.WarmC <= 0 {
+        manager.cfg.Default.WarmC = defaultWarmC
+    }
+    if manager.cfg.Default.HotC <= 0 {
+        manager.cfg.Default.HotC = defaultHotC
+    }
+    return manager
+}
+
+// readInt reads an integer sysfs attribute.
+func readInt(path string) (int, error) {
+    value, err := sysfsutil.Read(path)
+    if err != nil {
+        return 0, err
+    }
+    return strconv.Atoi(value)
+}
+
+// thresholds returns the thresholds of a zone by name, then by type.
+func (manager *ThermalManager) thresholds(name, kind string) config.ThermalThresholds {
+    thresholds, ok := manager.cfg.Zones[name]
+    if !ok {
+        thresholds, ok = manager.cfg.Zones[kind]
+    }
+    if !ok {
+        return manager.cfg.Default
+    }
+    if thresholds.WarmC <= 0 {
+        thresholds.WarmC = manager.cfg.Default.WarmC
+    }
+    if thresholds.HotC <= 0 {
+        thresholds.HotC = manager.cfg.Default.HotC
+    }
+    return thresholds
+}
+
+// discover finds the thermal zones and cooling devices.
+func (manager *ThermalManager) discover() {
+    zones, _ := filepath.Glob(filepath.Join(manager.root, pathThermalZones))
+    for _, dir := range zones {
+        name := filepath.Base(dir)
+        kind, _ := sysfsutil.Read(filepath.Join(dir, "type"))
+        thresholds := manager.thresholds(name, kind)
+        manager.zones = append(manager.zones, &ThermalZone{Name: name, Type: kind,
+            Level: levelNormal.String(), WarmC: thresholds.WarmC, HotC: thresholds.HotC})
+        log.Printf("Thermal zone %s (%s), warm %.1fC, hot %.1fC", name, kind, thresholds.WarmC, thresholds.HotC)
+    }
+    devices, _ := filepath.Glob(filepath.Join(manager.root, pathCoolingDevices))
+    for _, dir := range devices {
+        kind, _ := sysfsutil.Read(filepath.Join(dir, "type"))
+        manager.cooling = append(manager.cooling, CoolingDevice{Name: filepath.Base(dir), Type: kind})
+    }
+}
+
+// zoneLevel returns the new level of a zone at temp, moving down only once the
+// zone cooled below a threshold by the hysteresis.
+func (manager *ThermalManager) zoneLevel(zone *ThermalZone, temp float64) level {
+    target := levelNormal
+    if temp >= zone.HotC {
+        target = levelHot
+    } else if temp >= zone.WarmC {
+        target = levelWarm
+    }
+    if target >= zone.level {
+        return target
+    }
+    // Cooling down: stay until below the threshold of the current level.
+    downAt := zone.WarmC
+    if zone.level == levelHot {
+        downAt = zone.HotC
+    }
+    if temp > downAt-manager.cfg.HysteresisC {
+        return zone.level
+    }
+    if zone.level == levelHot && temp >= zone.WarmC-manager.cfg.HysteresisC {
+        return levelWarm
+    }
+    return target
+}
+
+// runHook runs a thermal hook for a zone.
+func (manager *ThermalManager) runHook(zone *ThermalZone) {
+    env := []string{
+        "POWERD_THERMAL_ZONE=" + zone.Name,
+        "POWERD_THERMAL_ZONE_TYPE=" + zone.Type,
+        "POWERD_THERMAL_TEMP_C=" + strconv.FormatFloat(zone.TempC, 'f', 1, 64),
+        "POWERD_THERMAL_LEVEL=" + zone.Level,
+    }
+    ctx, cancel := context.WithTimeout(manager.ctx, execTimeout*time.Millisecond)
+    defer cancel()
+    manager.runner.Run(ctx, zone.level.hook(), env)
+}
+
+// setHot applies or lifts the hot measures when the first zone becomes hot or
+// the last one cools down. The screen brightness cap is pinned, so a user
+// change does not lift it while hot.
+func (manager *ThermalManager) setHot(hot bool) {
+    manager.mutex.Lock()
+    changed := hot != manager.hot
+    manager.hot = hot
+    manager.mutex.Unlock()
+    if !changed {
+        return
+    }
+    if manager.cfg.HotScreenBrightnessPercent > 0 && manager.backlight != nil {
+        var err error
+        if hot {
+            err = manager.backlight.PinScreenBrightness(ownerThermal, manager.cfg.HotScreenBrightnessPercent)
+        } else {
+            err = manager.backlight.UncapScreenBrightness(ownerThermal)
+        }
+        if err != nil {
+            log.Printf("Thermal screen brightness error: %v", err)
+        }
+    }
+    if manager.cfg.HotPowerProfile != "" && manager.profile != nil {
+        if !hot {
+            manager.profile.Release(ownerThermal)
+        } else if err := manager.profile.Hold(ownerThermal, manager.cfg.HotPowerProfile); err != nil {
+            log.Printf("Thermal power profile error: %v", err)
+        }
+    }
+}
+
+// poll reads the zone temperatures and cooling device states, records them in
+// the history and runs the hooks of the zones that changed level.
+func (manager *ThermalManager) poll() error {
+    manager.mutex.Lock()
+    sample := ThermalSample{Time: time.Now().Unix(), Temps: make(map[string]float64)}
+    var changed []*ThermalZone
+    hot := false
+    for _, zone := range manager.zones {
+        millis, err := readInt(filepath.Join(manager.root, "class/thermal", zone.Name, "temp"))
+        if err != nil {
+            continue
+        }
+        zone.TempC = float64(millis) / 1000
+        sample.Temps[zone.Name] = zone.TempC
+        if next := manager.zoneLevel(zone, zone.TempC); next != zone.level {
+            log.Printf("Thermal zone %s %s at %.1fC", zone.Name, next, zone.TempC)
+            zone.level, zone.Level = next, next.String()
+            changed = append(changed, zone)
+        }
+        hot = hot || zone.level == levelHot
+    }
+    for i := range manager.cooling {
+        device := &manager.cooling[i]
+        dir := filepath.Join(manager.root, "class/thermal", device.Name)
+        device.CurState, _ = readInt(filepath.Join(dir, "cur_state"))
+        device.MaxState, _ = readInt(filepath.Join(dir, "max_state"))
+        if sample.Cooling == nil {
+            sample.Cooling = make(map[string]int)
+        }
+        sample.Cooling[device.Name] = device.CurState
+    }
+    manager.history = append(manager.history, sample)
+    if len(manager.history) > thermalHistorySize {
+        manager.history = manager.history[len(manager.history)-thermalHistorySize:]
+    }
+    manager.mutex.Unlock()
+
+    for _, zone := range changed {
+        manager.runHook(zone)
+    }
+    manager.setHot(hot)
+    return nil
+}
+
+// GetThermalState implements the GetThermalState D-Bus method. It returns the
+// zones, the cooling devices and the most recent count samples, oldest first,
+// as JSON; 0 returns all of them.
+func (manager *ThermalManager) GetThermalState(count uint32) (string, *dbus.Error) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    state := ThermalState{manager.zones, manager.cooling, manager.history}
+    if count > 0 && int(count) < len(state.History) {
+        state.History = state.History[len(state.History)-int(count):]
+    }
+    if state.Zones == nil {
+        state.Zones = []*ThermalZone{}
+    }
+    if state.CoolingDevices == nil {
+        state.CoolingDevices = []CoolingDevice{}
+    }
+    if state.History == nil {
+        state.History = []ThermalSample{}
+    }
+    buf, err := json.Marshal(&state)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
+
+// Register discovers the thermal zones and registers the thermal poll with
+// the signal server.
+func (manager *ThermalManager) Register(sigServer *dbusutil.SignalServer) error {
+    manager.discover()
+    if len(manager.zones) == 0 {
+        log.Println("No thermal zones found")
+        return nil
+    }
+    interval := defaultPollInterval
+    if manager.cfg.PollIntervalMs > 0 {
+        interval = time.Duration(manager.cfg.PollIntervalMs) * time.Millisecond
+    }
+    sigServer.RegisterTicker(interval, manager.poll)
+    log.Println("Thermal manager registered")
+    return nil
+}
+
+// RegisterMethods registers the thermal D-Bus methods with the service server.
+func (manager *ThermalManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetThermalState, manager.GetThermalState)
+}
+
+// UnRegister lifts the hot measures and unregisters the thermal manager from
+// the signal server.
+func (manager *ThermalManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    manager.setHot(false)
+    log.Println("Unregistering thermal manager")
+    return nil
+}
\ No newline at end of file
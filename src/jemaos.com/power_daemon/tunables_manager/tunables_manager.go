@@ -0,0 +1,286 @@
+package tunables_manager
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/godbus/dbus/v5"
+    "jemaos.com/power_daemon/battery_manager"
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/dbusutil"
+    "jemaos.com/power_daemon/sysfsutil"
+)
+
+const (
+    // D-Bus signal names for power supply updates and resume.
+    sigPowerSupplyPoll = "PowerSupplyPoll"
+    sigSuspendDone     = "SuspendDone"
+
+    // D-Bus method name for querying the applied tunables.
+    methdGetTunables = "GetTunables"
+
+    // PathRoot is the root of the file system the tunables are written to.
+    PathRoot = "/"
+
+    // Tunable attributes, relative to the root.
+    pathUsbDevices               = "sys/bus/usb/devices/*"
+    pathPciDevices               = "sys/bus/pci/devices/*"
+    pathSataHosts                = "sys/class/scsi_host/host*/link_power_management_policy"
+    pathAudioPowerSave           = "sys/module/snd_hda_intel/parameters/power_save"
+    pathAudioPowerSaveController = "sys/module/snd_hda_intel/parameters/power_save_controller"
+    pathVmDirtyWriteback         = "proc/sys/vm/dirty_writeback_centisecs"
+    pathVmLaptopMode             = "proc/sys/vm/laptop_mode"
+
+    // Runtime PM control attribute of a device and its enabled value.
+    attrPowerControl = "power/control"
+    controlAuto      = "auto"
+
+    // File keeping the values replaced by the tunables, relative to the state
+    // directory, so they are reverted after a daemon restart too.
+    fileTunables = "tunables.json"
+)
+
+// tunable is a value to write to an attribute.
+type tunable struct {
+    path  string
+    value string
+}
+
+// TunablesManager applies the power tunables while on battery and reverts
+// them on AC. Its D-Bus method is called from godbus goroutines, so all state
+// is guarded by mutex.
+type TunablesManager struct {
+    ctx        context.Context
+    battery    *battery_manager.BatteryManager
+    cfg        config.TunablesConfig
+    root       string
+    state_path string
+    mutex      sync.Mutex
+    applied    bool
+    // Attributes written on battery, with the values they replaced.
+    changes    sysfsutil.Changes
+}
+
+// NewTunablesManager initializes a new TunablesManager instance working on the
+// file system at root, normally PathRoot. The battery manager provides the
+// power source.
+func NewTunablesManager(ctx context.Context, cfg *config.Config, battery *battery_manager.BatteryManager, root string) *TunablesManager {
+    manager := &TunablesManager{ctx: ctx, battery: battery, cfg: cfg.Tunables, root: root,
+        state_path: filepath.Join(config.PathStateDir, fileTunables)}
+    if buf, err := ioutil.ReadFile(manager.state_path); err == nil {
+        if err := json.Unmarshal(buf, &manager.changes); err != nil {
+            log.Printf("Parse %s error: %v", manager.state_path, err)
+        }
+    }
+    return manager
+}
+
+// glob returns the paths matching a pattern relative to the root.
+func (manager *TunablesManager) glob(pattern string) []string {
+    paths, _ := filepath.Glob(filepath.Join(manager.root, pattern))
+    return paths
+}
+
+// usbId returns the "vid:pid" of a USB device directory.
+func usbId(dir string) string {
+    vendor, _ := sysfsutil.Read(filepath.Join(dir, "idVendor"))
+    product, _ := sysfsutil.Read(filepath.Join(dir, "idProduct"))
+    return strings.ToLower(vendor + ":" + product)
+}
+
+// contains reports whether ids holds id, ignoring case.
+func contains(ids []string, id string) bool {
+    for _, candidate := range ids {
+        if strings.EqualFold(candidate, id) {
+            return true
+        }
+    }
+    return false
+}
+
+// tunables returns the configured tunables for the attributes present.
+func (manager *TunablesManager) tunables() []tunable {
+    var tunables []tunable
+    if manager.cfg.UsbAutosuspend {
+        for _, dir := range manager.glob(pathUsbDevices) {
+            id := usbId(dir)
+            // Interfaces have no IDs and are skipped. Root hubs (vendor 1d6b)
+            // are kept, the kernel lets them autosuspend by default anyway.
+            if id == ":" || contains(manager.cfg.UsbDeny, id) ||
+                (len(manager.cfg.UsbAllow) > 0 && !contains(manager.cfg.UsbAllow, id)) {
+                continue
+            }
+            tunables = append(tunables, tunable{filepath.Join(dir, attrPowerControl), controlAuto})
+        }
+    }
+    if manager.cfg.PciRuntimePm {
+        for _, dir := range manager.glob(pathPciDevices) {
+            tunables = append(tunables, tunable{filepath.Join(dir, attrPowerControl), controlAuto})
+        }
+    }
+    if manager.cfg.SataLinkPm != "" {
+        for _, path := range manager.glob(pathSataHosts) {
+            tunables = append(tunables, tunable{path, manager.cfg.SataLinkPm})
+        }
+    }
+    if manager.cfg.AudioPowerSaveSec > 0 {
+        tunables = append(tunables,
+            tunable{filepath.Join(manager.root, pathAudioPowerSave), strconv.Itoa(manager.cfg.AudioPowerSaveSec)},
+            tunable{filepath.Join(manager.root, pathAudioPowerSaveController), "Y"})
+    }
+    if manager.cfg.VmDirtyWritebackCs > 0 {
+        tunables = append(tunables,
+            tunable{filepath.Join(manager.root, pathVmDirtyWriteback), strconv.Itoa(manager.cfg.VmDirtyWritebackCs)})
+    }
+    if manager.cfg.VmLaptopMode > 0 {
+        tunables = append(tunables,
+            tunable{filepath.Join(manager.root, pathVmLaptopMode), strconv.Itoa(manager.cfg.VmLaptopMode)})
+    }
+    return tunables
+}
+
+// saveChanges persists the changes, or removes the file once they are
+// reverted. Called with mutex held.
+func (manager *TunablesManager) saveChanges() error {
+    if len(manager.changes) == 0 {
+        if err := os.Remove(manager.state_path); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        return nil
+    }
+    buf, err := json.Marshal(manager.changes)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(manager.state_path), 0755); err != nil {
+        return err
+    }
+    // The file is read to revert the tunables after a crash, so it must not
+    // be left half written.
+    tmp := manager.state_path + ".tmp"
+    if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, manager.state_path)
+}
+
+// apply writes the tunables that are not set yet, e.g. of devices plugged in
+// since the last time. Called with mutex held.
+func (manager *TunablesManager) apply() {
+    written, failed := 0, 0
+    for _, t := range manager.tunables() {
+        changed, err := manager.changes.Set(t.path, t.value)
+        if err != nil {
+            log.Printf("Set tunable %s error: %v", t.path, err)
+            failed++
+        } else if changed {
+            written++
+        }
+    }
+    manager.applied = true
+    log.Printf("Applied tunables: %d written, %d failed, %d active", written, failed, len(manager.changes))
+    if err := manager.saveChanges(); err != nil {
+        log.Printf("Save tunables error: %v", err)
+    }
+}
+
+// revert writes back the values replaced by the tunables. Called with mutex held.
+func (manager *TunablesManager) revert() {
+    count := len(manager.changes)
+    if err := manager.changes.Revert(); err != nil {
+        log.Printf("Revert tunables error: %v", err)
+    }
+    log.Printf("Reverted %d tunables", count)
+    manager.applied = false
+    if err := manager.saveChanges(); err != nil {
+        log.Printf("Save tunables error: %v", err)
+    }
+}
+
+// HandlePowerSupplyPoll applies the tunables when the system switches to
+// battery and reverts them on AC. It runs after the battery manager's handler
+// of the signal.
+func (manager *TunablesManager) HandlePowerSupplyPoll(signal *dbus.Signal) error {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    onBattery := manager.battery.OnBattery()
+    if onBattery && !manager.applied {
+        manager.apply()
+    } else if !onBattery && manager.applied {
+        manager.revert()
+    }
+    return nil
+}
+
+// HandleSuspendDone applies the tunables again on battery, as devices may come
+// back from resume with their defaults or new devices may have appeared.
+func (manager *TunablesManager) HandleSuspendDone(signal *dbus.Signal) error {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    if manager.applied {
+        manager.apply()
+    }
+    return nil
+}
+
+// GetTunables implements the GetTunables D-Bus method. It returns the
+// attributes written on battery, with the values they replaced, as JSON.
+func (manager *TunablesManager) GetTunables() (string, *dbus.Error) {
+    manager.mutex.Lock()
+    defer manager.mutex.Unlock()
+    changes := manager.changes
+    if changes == nil {
+        changes = sysfsutil.Changes{}
+    }
+    buf, err := json.Marshal(changes)
+    if err != nil {
+        return "", dbus.MakeFailedError(err)
+    }
+    return string(buf), nil
+}
+
+// Register reverts tunables left over from a previous run and registers the
+// tunables manager with the signal server. They are applied again on the
+// first power supply poll on battery.
+func (manager *TunablesManager) Register(sigServer *dbusutil.SignalServer) error {
+    manager.mutex.Lock()
+    if len(manager.changes) > 0 {
+        manager.revert()
+    }
+    manager.mutex.Unlock()
+
+    pollHandler := func(sig *dbus.Signal) error {
+        return manager.HandlePowerSupplyPoll(sig)
+    }
+    resumeHandler := func(sig *dbus.Signal) error {
+        return manager.HandleSuspendDone(sig)
+    }
+    sigServer.RegisterSignalHandler(sigPowerSupplyPoll, pollHandler)
+    sigServer.RegisterSignalHandler(sigSuspendDone, resumeHandler)
+    log.Println("Tunables manager registered")
+    return nil
+}
+
+// RegisterMethods registers the tunables D-Bus methods with the service server.
+func (manager *TunablesManager) RegisterMethods(service *dbusutil.ServiceServer) {
+    service.RegisterMethod(methdGetTunables, manager.GetTunables)
+}
+
+// UnRegister reverts the tunables and unregisters the tunables manager from
+// the signal server.
+func (manager *TunablesManager) UnRegister(sigServer *dbusutil.SignalServer) error {
+    manager.mutex.Lock()
+    if manager.applied {
+        manager.revert()
+    }
+    manager.mutex.Unlock()
+    log.Println("Unregistering tunables manager")
+    return nil
+}
\ No newline at end of file
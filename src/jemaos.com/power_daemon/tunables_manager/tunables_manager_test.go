@@ -0,0 +1,67 @@
+package tunables_manager
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "jemaos.com/power_daemon/config"
+    "jemaos.com/power_daemon/sysfsutil"
+    "jemaos.com/power_daemon/sysfsutil/sysfstest"
+)
+
+func TestApplyTracksPathOnce(t *testing.T) {
+    root := t.TempDir()
+    sysfstest.WriteFiles(t, root, map[string]string{
+        "sys/bus/usb/devices/1-1/idVendor":          "046d",
+        "sys/bus/usb/devices/1-1/idProduct":         "c077",
+        "sys/bus/usb/devices/1-1/power/control":     "on",
+        // Interfaces have no IDs.
+        "sys/bus/usb/devices/1-1:1.0/power/control": "on",
+        "proc/sys/vm/laptop_mode":                   "0",
+    })
+    sysfstest.StateDir(t)
+    cfg := &config.Config{Tunables: config.TunablesConfig{UsbAutosuspend: true, VmLaptopMode: 5}}
+    manager := NewTunablesManager(context.Background(), cfg, nil, root)
+    want := sysfsutil.Changes{
+        {Path: filepath.Join(root, "sys/bus/usb/devices/1-1/power/control"), Value: controlAuto, Previous: "on"},
+        {Path: filepath.Join(root, "proc/sys/vm/laptop_mode"), Value: "5", Previous: "0"},
+    }
+    manager.apply()
+    if !reflect.DeepEqual(manager.changes, want) {
+        t.Fatalf("Got changes %v, want %v", manager.changes, want)
+    }
+
+    // The device comes back from resume with another value, and is written
+    // again without tracking the path twice.
+    sysfstest.WriteFiles(t, root, map[string]string{"sys/bus/usb/devices/1-1/power/control": "off"})
+    manager.apply()
+    if !reflect.DeepEqual(manager.changes, want) {
+        t.Errorf("Got changes %v after resume, want %v", manager.changes, want)
+    }
+    if got, _ := sysfsutil.Read(want[0].Path); got != controlAuto {
+        t.Errorf("Got %s %q, want %q", want[0].Path, got, controlAuto)
+    }
+
+    buf, err := ioutil.ReadFile(manager.state_path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var saved sysfsutil.Changes
+    if err := json.Unmarshal(buf, &saved); err != nil || !reflect.DeepEqual(saved, want) {
+        t.Errorf("Got saved changes %v, error: %v, want %v", saved, err, want)
+    }
+
+    if _, err := os.Stat(manager.state_path + ".tmp"); !os.IsNotExist(err) {
+        t.Errorf("Temporary state file left behind, stat error: %v", err)
+    }
+
+    manager.revert()
+    if got, _ := sysfsutil.Read(want[0].Path); got != "on" {
+        t.Errorf("Got %s %q after revert, want %q", want[0].Path, got, "on")
+    }
+}
\ No newline at end of file